@@ -0,0 +1,75 @@
+package config
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLoadDefaults(t *testing.T) {
+	got, args, err := Load("todo-api", []string{"migrate", "up"}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got, Default()) {
+		t.Fatalf("Want: %+v, Got: %+v", Default(), got)
+	}
+
+	if want := []string{"migrate", "up"}; !reflect.DeepEqual(args, want) {
+		t.Fatalf("Want: %v, Got: %v", want, args)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.json")
+	body := `{"addr": ":7000", "read_timeout": "1s", "db-max-open-conns": 20, "log_level": "debug"}`
+	if err := os.WriteFile(file, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("TODO_CONFIG", file)
+	t.Setenv("TODO_ADDR", ":7001")
+	t.Setenv("TODO_READ_TIMEOUT", "2s")
+
+	got, _, err := Load("todo-api", []string{"-addr", ":7002"}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Addr != ":7002" {
+		t.Fatalf("flag: Want: %v, Got: %v", ":7002", got.Addr)
+	}
+	if got.ReadTimeout != 2*time.Second {
+		t.Fatalf("env: Want: %v, Got: %v", 2*time.Second, got.ReadTimeout)
+	}
+	if got.MaxOpenConns != 20 || got.LogLevel != slog.LevelDebug {
+		t.Fatalf("file: Want: 20 and DEBUG, Got: %v and %v", got.MaxOpenConns, got.LogLevel)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(file, []byte(`{"unknown": 1}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := [][]string{
+		{"-config", file},
+		{"-log-level", "loud"},
+		{"-dsn", ""},
+		{"-backend", "mysql"},
+		{"-db-max-open-conns", "1", "-db-max-idle-conns", "2"},
+		{"-read-timeout", "-1s"},
+	}
+
+	for _, args := range tests {
+		if _, _, err := Load("todo-api", args, io.Discard); err == nil {
+			t.Fatalf("%v: Want an error", args)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package db_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/cohhei/go-to-the-handson/04/db"
+	"github.com/cohhei/go-to-the-handson/04/db/dbtest"
+	"github.com/cohhei/go-to-the-handson/04/schema"
+	"github.com/cohhei/go-to-the-handson/04/testdb"
+)
+
+func TestPostgresConformance(t *testing.T) {
+	dbtest.RunRepositoryConformance(t, func(t *testing.T) db.Repository {
+		return &db.Postgres{DB: testdb.Setup(t)}
+	})
+}
+
+func TestSQLiteConformance(t *testing.T) {
+	dbtest.RunRepositoryConformance(t, func(t *testing.T) db.Repository {
+		sqlite, err := db.ConnectSQLite(filepath.Join(t.TempDir(), "todo.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sqlite
+	})
+}
+
+func TestFileStoreConformance(t *testing.T) {
+	dbtest.RunRepositoryConformance(t, func(t *testing.T) db.Repository {
+		store, err := db.OpenFileStore(filepath.Join(t.TempDir(), "todo.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	})
+}
+
+func TestMemoryConformance(t *testing.T) {
+	dbtest.RunRepositoryConformance(t, func(t *testing.T) db.Repository {
+		return db.NewMemory()
+	})
+}
+
+func TestPostgresTx(t *testing.T) {
+	pool := testdb.Setup(t)
+
+	t.Run("Insert", func(t *testing.T) {
+		postgres := testdb.Tx(t, pool)
+		defer postgres.Close()
+
+		if _, err := postgres.Insert(context.Background(), &schema.Todo{Title: "title1"}); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := postgres.GetAll(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 1 {
+			t.Fatalf("Want: 1 todo, Got: %v", got)
+		}
+	})
+
+	got, err := (&db.Postgres{DB: pool}).GetAll(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Fatalf("The insert is not rolled back, Got: %v", got)
+	}
+}
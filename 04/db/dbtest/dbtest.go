@@ -0,0 +1,685 @@
+// Package dbtest provides a conformance suite that every db.Repository
+// implementation must pass.
+package dbtest
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cohhei/go-to-the-handson/04/db"
+	"github.com/cohhei/go-to-the-handson/04/schema"
+)
+
+// RunRepositoryConformance runs the conformance tests, each against a fresh
+// and empty repository made by newRepository. The repository is closed when
+// the test ends.
+func RunRepositoryConformance(t *testing.T, newRepository func(t *testing.T) db.Repository) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, repository db.Repository)
+	}{
+		{"Insert", testInsert},
+		{"GetAll", testGetAll},
+		{"Delete", testDelete},
+		{"Get", testGet},
+		{"Update", testUpdate},
+		{"UpdateNotFound", testUpdateNotFound},
+		{"Timestamps", testTimestamps},
+		{"UpdateConflict", testUpdateConflict},
+		{"DeleteNotFound", testDeleteNotFound},
+		{"List", testList},
+		{"Ordering", testOrdering},
+		{"TimeZones", testTimeZones},
+		{"Concurrent", testConcurrent},
+		{"InsertMany", testInsertMany},
+		{"DeleteMany", testDeleteMany},
+		{"WithinTx", testWithinTx},
+		{"WithinTxRollback", testWithinTxRollback},
+		{"WithinTxPanic", testWithinTxPanic},
+		{"WithinTxNested", testWithinTxNested},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repository := newRepository(t)
+			defer repository.Close()
+
+			tt.test(t, repository)
+		})
+	}
+}
+
+func testInsert(t *testing.T, repository db.Repository) {
+	todo := &schema.Todo{
+		Title:   "title1",
+		Note:    "note1",
+		DueDate: time.Date(2000, 1, 1, 0, 0, 0, 0, time.Local),
+	}
+
+	got, err := repository.Insert(context.Background(), todo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := 1
+
+	if got != want {
+		t.Fatalf("Want: %v, Got: %v", want, got)
+	}
+}
+
+func testGetAll(t *testing.T, repository db.Repository) {
+	todo := &schema.Todo{
+		Title:   "title1",
+		Note:    "note1",
+		DueDate: time.Date(2000, 1, 1, 0, 0, 0, 0, time.Local),
+	}
+
+	_, err := repository.Insert(context.Background(), todo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := repository.GetAll(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []schema.Todo{
+		{
+			ID:      1,
+			Title:   "title1",
+			Note:    "note1",
+			DueDate: time.Date(2000, 1, 1, 0, 0, 0, 0, time.Local),
+			Status:  schema.StatusOpen,
+			Version: 1,
+		},
+	}
+
+	if !equal(got, want) {
+		t.Fatalf("Want: %v, Got: %v", want, got)
+	}
+}
+
+func testDelete(t *testing.T, repository db.Repository) {
+	todo := &schema.Todo{
+		Title:   "title1",
+		Note:    "note1",
+		DueDate: time.Date(2000, 1, 1, 0, 0, 0, 0, time.Local),
+	}
+
+	id, err := repository.Insert(context.Background(), todo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = repository.Delete(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := repository.GetAll(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) > 0 {
+		t.Fatal("The record is not deleted.")
+	}
+}
+
+func testGet(t *testing.T, repository db.Repository) {
+	todo := &schema.Todo{
+		Title:   "title1",
+		Note:    "note1",
+		DueDate: time.Date(2000, 1, 1, 0, 0, 0, 0, time.Local),
+	}
+
+	id, err := repository.Insert(context.Background(), todo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := repository.Get(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &schema.Todo{
+		ID:      id,
+		Title:   "title1",
+		Note:    "note1",
+		DueDate: time.Date(2000, 1, 1, 0, 0, 0, 0, time.Local),
+		Status:  schema.StatusOpen,
+		Version: 1,
+	}
+
+	if !equal(got, want) {
+		t.Fatalf("Want: %v, Got: %v", want, got)
+	}
+
+	if _, err := repository.Get(context.Background(), id+1); err != db.ErrNotFound {
+		t.Fatalf("Want: %v, Got: %v", db.ErrNotFound, err)
+	}
+}
+
+func testUpdate(t *testing.T, repository db.Repository) {
+	todo := &schema.Todo{
+		Title:   "title1",
+		Note:    "note1",
+		DueDate: time.Date(2000, 1, 1, 0, 0, 0, 0, time.Local),
+	}
+
+	id, err := repository.Insert(context.Background(), todo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := schema.Todo{
+		ID:      id,
+		Title:   "title2",
+		Note:    "note2",
+		DueDate: time.Date(2001, 2, 3, 0, 0, 0, 0, time.Local),
+		Status:  schema.StatusOpen,
+	}
+
+	if err := repository.Update(context.Background(), &want); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := repository.GetAll(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !equal(got, []schema.Todo{want}) {
+		t.Fatalf("Want: %v, Got: %v", want, got)
+	}
+}
+
+func testUpdateNotFound(t *testing.T, repository db.Repository) {
+	err := repository.Update(context.Background(), &schema.Todo{ID: 1, Title: "title1"})
+	if err != db.ErrNotFound {
+		t.Fatalf("Want: %v, Got: %v", db.ErrNotFound, err)
+	}
+}
+
+func testTimestamps(t *testing.T, repository db.Repository) {
+	ctx := context.Background()
+
+	before := time.Now().Truncate(time.Microsecond)
+	todo := &schema.Todo{Title: "title1"}
+	id, err := repository.Insert(ctx, todo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if todo.UpdatedAt.Before(before) || !todo.CreatedAt.Equal(todo.UpdatedAt) {
+		t.Fatalf("Want: both after %v, Got: %v and %v", before, todo.CreatedAt, todo.UpdatedAt)
+	}
+
+	got, err := repository.Get(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.CreatedAt.Equal(todo.CreatedAt) || !got.UpdatedAt.Equal(todo.UpdatedAt) {
+		t.Fatalf("Want: %v and %v, Got: %v and %v", todo.CreatedAt, todo.UpdatedAt, got.CreatedAt, got.UpdatedAt)
+	}
+
+	// The creation time is kept whatever the updated todo holds.
+	inserted := todo.CreatedAt
+	todo.ID = id
+	todo.Title = "title2"
+	todo.CreatedAt = time.Time{}
+	if err := repository.Update(ctx, todo); err != nil {
+		t.Fatal(err)
+	}
+
+	if !todo.CreatedAt.Equal(inserted) || todo.UpdatedAt.Before(inserted) {
+		t.Fatalf("Want: %v and after it, Got: %v and %v", inserted, todo.CreatedAt, todo.UpdatedAt)
+	}
+
+	got, err = repository.Get(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.CreatedAt.Equal(inserted) || !got.UpdatedAt.Equal(todo.UpdatedAt) {
+		t.Fatalf("Want: %v and %v, Got: %v and %v", inserted, todo.UpdatedAt, got.CreatedAt, got.UpdatedAt)
+	}
+}
+
+func testUpdateConflict(t *testing.T, repository db.Repository) {
+	ctx := context.Background()
+
+	id, err := repository.Insert(ctx, &schema.Todo{Title: "title1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := &schema.Todo{ID: id, Title: "title2", Version: 1}
+	if err := repository.Update(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+	if first.Version != 2 {
+		t.Fatalf("Want: %v, Got: %v", 2, first.Version)
+	}
+
+	// A second writer that also read version 1 must not overwrite title2.
+	err = repository.Update(ctx, &schema.Todo{ID: id, Title: "title3", Version: 1})
+	var conflictErr *db.ConflictError
+	if !errors.As(err, &conflictErr) {
+		t.Fatalf("Want: %T, Got: %v", conflictErr, err)
+	}
+	if conflictErr.Current.Title != "title2" || conflictErr.Current.Version != 2 {
+		t.Fatalf("Want: %v, Got: %v", first, conflictErr.Current)
+	}
+
+	// A zero version overwrites whatever is stored.
+	last := &schema.Todo{ID: id, Title: "title4"}
+	if err := repository.Update(ctx, last); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := repository.Get(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != "title4" || got.Version != 3 || last.Version != 3 {
+		t.Fatalf("Want: %v, Got: %v", last, got)
+	}
+
+	err = repository.Update(ctx, &schema.Todo{ID: id + 1, Title: "title5", Version: 1})
+	if err != db.ErrNotFound {
+		t.Fatalf("Want: %v, Got: %v", db.ErrNotFound, err)
+	}
+}
+
+func testDeleteNotFound(t *testing.T, repository db.Repository) {
+	if err := repository.Delete(context.Background(), 1); err != db.ErrNotFound {
+		t.Fatalf("Want: %v, Got: %v", db.ErrNotFound, err)
+	}
+}
+
+func testList(t *testing.T, repository db.Repository) {
+	for i, title := range []string{"b task", "a task", "c 100%"} {
+		todo := &schema.Todo{
+			Title:   title,
+			DueDate: time.Date(2000, 1, i+1, 0, 0, 0, 0, time.UTC),
+		}
+		if _, err := repository.Insert(context.Background(), todo); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		opts    db.ListOptions
+		wantIDs []int
+		total   int
+	}{
+		{db.ListOptions{}, []int{1, 2, 3}, 3},
+		{db.ListOptions{Limit: 2, Offset: 1}, []int{2, 3}, 3},
+		{db.ListOptions{Sort: "title"}, []int{2, 1, 3}, 3},
+		{db.ListOptions{Sort: "-id"}, []int{3, 2, 1}, 3},
+		{db.ListOptions{Query: "TASK"}, []int{1, 2}, 2},
+		{db.ListOptions{Query: "%"}, []int{3}, 1},
+		{db.ListOptions{DueAfter: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)}, []int{2, 3}, 2},
+		{db.ListOptions{DueBefore: time.Date(2000, 1, 3, 0, 0, 0, 0, time.UTC), Sort: "-due_date"}, []int{2, 1}, 2},
+	}
+
+	for _, tt := range tests {
+		got, total, err := repository.List(context.Background(), tt.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		gotIDs := []int{}
+		for _, todo := range got {
+			gotIDs = append(gotIDs, todo.ID)
+		}
+
+		if !equal(gotIDs, tt.wantIDs) || total != tt.total {
+			t.Fatalf("%+v: Want: %v (%d), Got: %v (%d)", tt.opts, tt.wantIDs, tt.total, gotIDs, total)
+		}
+	}
+}
+
+func testOrdering(t *testing.T, repository db.Repository) {
+	ctx := context.Background()
+
+	for _, title := range []string{"title1", "title2", "title3"} {
+		if _, err := repository.Insert(ctx, &schema.Todo{Title: title}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Updating or deleting todos must not change the order of the others.
+	if err := repository.Update(ctx, &schema.Todo{ID: 1, Title: "updated"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repository.Delete(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	id, err := repository.Insert(ctx, &schema.Todo{Title: "title4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 4 {
+		t.Fatalf("IDs must not be reused, Want: %v, Got: %v", 4, id)
+	}
+
+	got, err := repository.GetAll(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gotIDs := []int{}
+	for _, todo := range got {
+		gotIDs = append(gotIDs, todo.ID)
+	}
+
+	if want := []int{1, 3, 4}; !equal(gotIDs, want) {
+		t.Fatalf("Want: %v, Got: %v", want, gotIDs)
+	}
+}
+
+func testTimeZones(t *testing.T, repository db.Repository) {
+	ctx := context.Background()
+
+	tokyo := time.FixedZone("JST", 9*60*60)
+	newYork := time.FixedZone("EST", -5*60*60)
+	completedAt := time.Date(2000, 1, 2, 3, 4, 5, 0, newYork)
+	todo := &schema.Todo{
+		Title: "title1",
+		// 1999-12-31 23:00 in UTC.
+		DueDate:     time.Date(2000, 1, 1, 8, 0, 0, 0, tokyo),
+		Status:      schema.StatusDone,
+		CompletedAt: &completedAt,
+	}
+
+	id, err := repository.Insert(ctx, todo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := repository.Get(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !got.DueDate.Equal(todo.DueDate) {
+		t.Fatalf("Want: %v, Got: %v", todo.DueDate, got.DueDate)
+	}
+	if got.CompletedAt == nil || !got.CompletedAt.Equal(completedAt) {
+		t.Fatalf("Want: %v, Got: %v", completedAt, got.CompletedAt)
+	}
+
+	// Filters compare instants, not the local times of the todos.
+	tests := []struct {
+		opts  db.ListOptions
+		total int
+	}{
+		{db.ListOptions{DueBefore: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)}, 1},
+		{db.ListOptions{DueAfter: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)}, 0},
+		{db.ListOptions{DueAfter: time.Date(1999, 12, 31, 17, 0, 0, 0, newYork)}, 1},
+	}
+
+	for _, tt := range tests {
+		_, total, err := repository.List(ctx, tt.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if total != tt.total {
+			t.Fatalf("%+v: Want: %v, Got: %v", tt.opts, tt.total, total)
+		}
+	}
+}
+
+func testConcurrent(t *testing.T, repository db.Repository) {
+	ctx := context.Background()
+
+	const n = 20
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			id, err := repository.Insert(ctx, &schema.Todo{Title: "title"})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if err := repository.Update(ctx, &schema.Todo{ID: id, Title: "updated"}); err != nil {
+				t.Error(err)
+			}
+			if _, _, err := repository.List(ctx, db.ListOptions{Limit: 10}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	got, err := repository.GetAll(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != n {
+		t.Fatalf("Want: %v todos, Got: %v", n, len(got))
+	}
+	for i, todo := range got {
+		if todo.ID != i+1 || todo.Title != "updated" {
+			t.Fatalf("Want: %v, Got: %v", schema.Todo{ID: i + 1, Title: "updated"}, todo)
+		}
+	}
+}
+
+func testInsertMany(t *testing.T, repository db.Repository) {
+	ctx := context.Background()
+
+	if _, err := repository.Insert(ctx, &schema.Todo{Title: "title1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	todos := []schema.Todo{
+		{Title: "title2", Note: "note2"},
+		{Title: "title3", Status: schema.StatusInProgress},
+	}
+	got, err := repository.InsertMany(ctx, todos)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []int{2, 3}; !equal(got, want) {
+		t.Fatalf("Want: %v, Got: %v", want, got)
+	}
+
+	todo, err := repository.Get(ctx, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if todo.Title != "title3" || todo.Status != schema.StatusInProgress {
+		t.Fatalf("Want: %v, Got: %v", todos[1], todo)
+	}
+
+	empty, err := repository.InsertMany(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(empty) != 0 {
+		t.Fatalf("Want: [], Got: %v", empty)
+	}
+}
+
+func testDeleteMany(t *testing.T, repository db.Repository) {
+	ctx := context.Background()
+
+	if _, err := repository.InsertMany(ctx, []schema.Todo{{Title: "title1"}, {Title: "title2"}, {Title: "title3"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := repository.DeleteMany(ctx, []int{3, 4, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []int{3, 1}; !equal(got, want) {
+		t.Fatalf("Want: %v, Got: %v", want, got)
+	}
+
+	assertTitles(t, repository, []string{"title2"})
+}
+
+func testWithinTx(t *testing.T, repository db.Repository) {
+	ctx := context.Background()
+
+	var id int
+	err := repository.WithinTx(ctx, func(tx db.Repository) error {
+		var err error
+		id, err = tx.Insert(ctx, &schema.Todo{Title: "title1"})
+		if err != nil {
+			return err
+		}
+
+		return tx.Update(ctx, &schema.Todo{ID: id, Title: "updated"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := repository.Get(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Title != "updated" {
+		t.Fatalf("Want: %v, Got: %v", "updated", got.Title)
+	}
+}
+
+func testWithinTxRollback(t *testing.T, repository db.Repository) {
+	ctx := context.Background()
+
+	id, err := repository.Insert(ctx, &schema.Todo{Title: "title1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := errors.New("rollback")
+	err = repository.WithinTx(ctx, func(tx db.Repository) error {
+		if _, err := tx.Insert(ctx, &schema.Todo{Title: "title2"}); err != nil {
+			return err
+		}
+		if err := tx.Delete(ctx, id); err != nil {
+			return err
+		}
+
+		return want
+	})
+	if err != want {
+		t.Fatalf("Want: %v, Got: %v", want, err)
+	}
+
+	assertTitles(t, repository, []string{"title1"})
+}
+
+func testWithinTxPanic(t *testing.T, repository db.Repository) {
+	ctx := context.Background()
+
+	func() {
+		defer func() {
+			if p := recover(); p != "boom" {
+				t.Fatalf("Want: %v, Got: %v", "boom", p)
+			}
+		}()
+
+		repository.WithinTx(ctx, func(tx db.Repository) error {
+			if _, err := tx.Insert(ctx, &schema.Todo{Title: "title1"}); err != nil {
+				return err
+			}
+			panic("boom")
+		})
+	}()
+
+	assertTitles(t, repository, []string{})
+
+	// The repository is still usable after the panic.
+	if _, err := repository.Insert(ctx, &schema.Todo{Title: "title2"}); err != nil {
+		t.Fatal(err)
+	}
+	assertTitles(t, repository, []string{"title2"})
+}
+
+// testWithinTxNested runs WithinTxRollback against the repository of a
+// transaction, whose own transactions must roll back without it.
+func testWithinTxNested(t *testing.T, repository db.Repository) {
+	err := repository.WithinTx(context.Background(), func(tx db.Repository) error {
+		testWithinTxRollback(t, tx)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertTitles(t, repository, []string{"title1"})
+}
+
+func assertTitles(t *testing.T, repository db.Repository, want []string) {
+	t.Helper()
+
+	todoList, err := repository.GetAll(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := []string{}
+	for _, todo := range todoList {
+		got = append(got, todo.Title)
+	}
+
+	if !equal(got, want) {
+		t.Fatalf("Want: %v, Got: %v", want, got)
+	}
+}
+
+// equal reports whether got and want are deeply equal, treating times of
+// todos at the same instant as equal whatever their location is. CreatedAt
+// and UpdatedAt are ignored, as they are set by the repository and checked
+// by testTimestamps.
+func equal(got interface{}, want interface{}) bool {
+	return reflect.DeepEqual(normalize(got), normalize(want))
+}
+
+func normalize(v interface{}) interface{} {
+	switch v := v.(type) {
+	case schema.Todo:
+		v.DueDate = v.DueDate.UTC()
+		v.CreatedAt = time.Time{}
+		v.UpdatedAt = time.Time{}
+		if v.CompletedAt != nil {
+			completedAt := v.CompletedAt.UTC()
+			v.CompletedAt = &completedAt
+		}
+		return v
+	case *schema.Todo:
+		if v == nil {
+			return v
+		}
+		t := normalize(*v).(schema.Todo)
+		return &t
+	case []schema.Todo:
+		todoList := []schema.Todo{}
+		for _, t := range v {
+			todoList = append(todoList, normalize(t).(schema.Todo))
+		}
+		return todoList
+	}
+
+	return v
+}
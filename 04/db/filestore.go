@@ -0,0 +1,509 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/cohhei/go-to-the-handson/04/schema"
+)
+
+// compactEvery is the number of logged changes after which a FileStore
+// rewrites its snapshot and empties its log.
+const compactEvery = 1000
+
+// FileStore is a Repository that keeps todos in memory and persists them to
+// a JSON snapshot file. Every change is appended to a write-ahead log next to
+// the snapshot and synced to disk before it is applied, and the log is folded
+// into the snapshot when the store is opened or closed and once it grows
+// large. Only one FileStore may use a file at a time, which is enforced with
+// a lock on the log where the system supports it.
+type FileStore struct {
+	mu      sync.Mutex
+	path    string
+	todos   *Memory
+	lastID  int
+	wal     *os.File
+	records int
+}
+
+type snapshot struct {
+	LastID int           `json:"last_id"`
+	Todos  []schema.Todo `json:"todos"`
+}
+
+const (
+	opPut    = "put"
+	opDelete = "delete"
+	opTx     = "tx"
+)
+
+type walRecord struct {
+	Op   string       `json:"op"`
+	Todo *schema.Todo `json:"todo,omitempty"`
+	ID   int          `json:"id,omitempty"`
+
+	// A tx record holds the changes of a transaction, which are written as
+	// one line and so are replayed all or not at all.
+	Records []walRecord `json:"records,omitempty"`
+	LastID  int         `json:"last_id,omitempty"`
+}
+
+// OpenFileStore loads the todos saved at path, creating the file if needed,
+// and replays the changes logged since the last snapshot. The log stays
+// locked until the store is closed, so that opening a file that another
+// FileStore uses fails.
+func OpenFileStore(path string) (*FileStore, error) {
+	s := &FileStore{path: path, todos: NewMemory()}
+
+	var err error
+	s.wal, err = os.OpenFile(s.walPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := lockFile(s.wal); err != nil {
+		s.wal.Close()
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	if err := s.load(); err != nil {
+		s.wal.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// load reads the snapshot, replays the log and folds it into the snapshot.
+func (s *FileStore) load() error {
+	b, err := os.ReadFile(s.path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		var snap snapshot
+		if err := json.Unmarshal(b, &snap); err != nil {
+			return fmt.Errorf("%s: %v", s.path, err)
+		}
+		s.lastID = snap.LastID
+		for _, t := range snap.Todos {
+			s.todos.put(t)
+		}
+	}
+
+	if err := s.replay(); err != nil {
+		return err
+	}
+
+	return s.compact()
+}
+
+func (s *FileStore) walPath() string {
+	return s.path + ".wal"
+}
+
+// replay applies the logged changes. A record is only written once its
+// trailing newline is synced, so an incomplete last line is a write that was
+// cut short by a crash and is dropped.
+func (s *FileStore) replay() error {
+	b, err := os.ReadFile(s.walPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	lines := bytes.Split(b, []byte("\n"))
+	for i, line := range lines[:len(lines)-1] {
+		var rec walRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return fmt.Errorf("%s:%d: %v", s.walPath(), i+1, err)
+		}
+		if err := s.apply(rec); err != nil {
+			return fmt.Errorf("%s:%d: %v", s.walPath(), i+1, err)
+		}
+	}
+
+	return nil
+}
+
+// apply applies rec to the todos in memory. Records are replayed again if
+// the log could not be emptied after a snapshot, so applying one twice has
+// no further effect.
+func (s *FileStore) apply(rec walRecord) error {
+	switch rec.Op {
+	case opPut:
+		if rec.Todo == nil {
+			return fmt.Errorf("%s record without a todo", rec.Op)
+		}
+		s.todos.put(*rec.Todo)
+		if rec.Todo.ID > s.lastID {
+			s.lastID = rec.Todo.ID
+		}
+	case opDelete:
+		s.todos.remove(rec.ID)
+	case opTx:
+		for _, r := range rec.Records {
+			if err := s.apply(r); err != nil {
+				return err
+			}
+		}
+		if rec.LastID > s.lastID {
+			s.lastID = rec.LastID
+		}
+	default:
+		return fmt.Errorf("unknown operation %q", rec.Op)
+	}
+
+	return nil
+}
+
+// log appends rec to the write-ahead log, syncs it and applies it. The
+// caller must hold s.mu.
+func (s *FileStore) log(rec walRecord) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	info, err := s.wal.Stat()
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.wal.Write(append(b, '\n')); err != nil {
+		// Drop what was written of the record, so that the next one does
+		// not follow a broken line.
+		s.wal.Truncate(info.Size())
+		return err
+	}
+	if err := s.wal.Sync(); err != nil {
+		return err
+	}
+
+	if err := s.apply(rec); err != nil {
+		return err
+	}
+
+	s.records++
+	if s.records >= compactEvery {
+		// The change is durable in the log, so a failed compaction is
+		// retried on the next change instead of failing this one.
+		s.compact()
+	}
+
+	return nil
+}
+
+// compact writes the snapshot and empties the log. The caller must hold
+// s.mu.
+func (s *FileStore) compact() error {
+	todoList, err := s.todos.GetAll(context.Background())
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(snapshot{LastID: s.lastID, Todos: todoList})
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(s.path, b); err != nil {
+		return err
+	}
+
+	if err := s.wal.Truncate(0); err != nil {
+		return err
+	}
+	if err := s.wal.Sync(); err != nil {
+		return err
+	}
+	s.records = 0
+
+	return nil
+}
+
+// writeFileAtomic replaces the file at path with b, so that after a crash
+// the file holds either its old or its new content.
+func writeFileAtomic(path string, b []byte) error {
+	dir := filepath.Dir(path)
+
+	f, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(f.Name(), path); err != nil {
+		return err
+	}
+
+	// Sync the directory so that the rename itself survives a crash.
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
+
+func (s *FileStore) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Whatever is not in the snapshot is still in the log, so a failed
+	// compaction loses nothing.
+	s.compact()
+	s.wal.Close()
+}
+
+// WithinTx calls fn with a repository that keeps the changes fn makes in a
+// redo log, and writes the log as a single record if fn returns nil. s is
+// locked meanwhile, so transactions run one at a time.
+func (s *FileStore) WithinTx(ctx context.Context, fn func(repository Repository) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx := &fileStoreTx{s: s, lastID: s.lastID, changes: map[int]*schema.Todo{}}
+	if err := tx.WithinTx(ctx, fn); err != nil {
+		return err
+	}
+
+	if len(tx.records) == 0 && tx.lastID == s.lastID {
+		return nil
+	}
+
+	return s.log(walRecord{Op: opTx, Records: tx.records, LastID: tx.lastID})
+}
+
+// fileStoreTx is the Repository of a FileStore transaction. Its changes are
+// logged in records and kept in changes, where nil marks a deleted todo,
+// and are not applied to the stored todos before the transaction commits.
+// Its caller holds s.mu.
+type fileStoreTx struct {
+	s       *FileStore
+	lastID  int
+	records []walRecord
+	changes map[int]*schema.Todo
+}
+
+// record logs rec and keeps the change it makes.
+func (tx *fileStoreTx) record(rec walRecord) {
+	tx.records = append(tx.records, rec)
+	if rec.Op == opPut {
+		tx.changes[rec.Todo.ID] = rec.Todo
+	} else {
+		tx.changes[rec.ID] = nil
+	}
+}
+
+func (tx *fileStoreTx) Close() {}
+
+func (tx *fileStoreTx) Insert(ctx context.Context, todo *schema.Todo) (int, error) {
+	created(todo)
+	t := copyTodo(*todo)
+	tx.lastID++
+	t.ID = tx.lastID
+	t.Status = statusOrDefault(t.Status)
+	tx.record(walRecord{Op: opPut, Todo: &t})
+
+	return t.ID, nil
+}
+
+func (tx *fileStoreTx) InsertMany(ctx context.Context, todos []schema.Todo) ([]int, error) {
+	return insertEach(ctx, tx, todos)
+}
+
+func (tx *fileStoreTx) DeleteMany(ctx context.Context, ids []int) ([]int, error) {
+	return deleteEach(ctx, tx, ids)
+}
+
+func (tx *fileStoreTx) Update(ctx context.Context, todo *schema.Todo) error {
+	stored, err := tx.Get(ctx, todo.ID)
+	if err != nil {
+		return err
+	}
+	if todo.Version != 0 && todo.Version != stored.Version {
+		return &ConflictError{Current: stored}
+	}
+
+	touch(todo)
+	todo.CreatedAt = stored.CreatedAt
+	todo.Version = stored.Version + 1
+	t := copyTodo(*todo)
+	t.Status = statusOrDefault(t.Status)
+	tx.record(walRecord{Op: opPut, Todo: &t})
+
+	return nil
+}
+
+func (tx *fileStoreTx) Delete(ctx context.Context, id int) error {
+	if _, err := tx.Get(ctx, id); err != nil {
+		return err
+	}
+	tx.record(walRecord{Op: opDelete, ID: id})
+
+	return nil
+}
+
+func (tx *fileStoreTx) Get(ctx context.Context, id int) (*schema.Todo, error) {
+	t, ok := tx.changes[id]
+	if !ok {
+		return tx.s.todos.Get(ctx, id)
+	}
+	if t == nil {
+		return nil, ErrNotFound
+	}
+	c := copyTodo(*t)
+
+	return &c, nil
+}
+
+// GetAll returns the stored todos with the changes of tx applied, ordered by
+// ID.
+func (tx *fileStoreTx) GetAll(ctx context.Context) ([]schema.Todo, error) {
+	stored, err := tx.s.todos.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	todoList := make([]schema.Todo, 0, len(stored)+len(tx.changes))
+	for _, t := range stored {
+		if _, ok := tx.changes[t.ID]; !ok {
+			todoList = append(todoList, t)
+		}
+	}
+	for _, t := range tx.changes {
+		if t != nil {
+			todoList = append(todoList, copyTodo(*t))
+		}
+	}
+
+	sort.Slice(todoList, func(i, j int) bool {
+		return todoList[i].ID < todoList[j].ID
+	})
+
+	return todoList, nil
+}
+
+func (tx *fileStoreTx) List(ctx context.Context, opts ListOptions) ([]schema.Todo, int, error) {
+	all, err := tx.GetAll(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	todoList, total := listTodos(all, opts)
+	return todoList, total, nil
+}
+
+// WithinTx calls fn with tx and drops the changes fn made if it fails or
+// panics, like a savepoint.
+func (tx *fileStoreTx) WithinTx(ctx context.Context, fn func(repository Repository) error) error {
+	n, lastID := len(tx.records), tx.lastID
+	committed := false
+	defer func() {
+		if committed {
+			return
+		}
+		records := tx.records[:n]
+		tx.records, tx.lastID, tx.changes = nil, lastID, map[int]*schema.Todo{}
+		for _, rec := range records {
+			tx.record(rec)
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	committed = true
+
+	return nil
+}
+
+func (s *FileStore) Insert(ctx context.Context, todo *schema.Todo) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	created(todo)
+	t := copyTodo(*todo)
+	t.ID = s.lastID + 1
+	t.Status = statusOrDefault(t.Status)
+
+	if err := s.log(walRecord{Op: opPut, Todo: &t}); err != nil {
+		return -1, err
+	}
+
+	return t.ID, nil
+}
+
+func (s *FileStore) InsertMany(ctx context.Context, todos []schema.Todo) ([]int, error) {
+	return insertEach(ctx, s, todos)
+}
+
+func (s *FileStore) DeleteMany(ctx context.Context, ids []int) ([]int, error) {
+	return deleteEach(ctx, s, ids)
+}
+
+func (s *FileStore) Update(ctx context.Context, todo *schema.Todo) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, err := s.todos.Get(ctx, todo.ID)
+	if err != nil {
+		return err
+	}
+	if todo.Version != 0 && todo.Version != stored.Version {
+		return &ConflictError{Current: stored}
+	}
+
+	touch(todo)
+	todo.CreatedAt = stored.CreatedAt
+	todo.Version = stored.Version + 1
+	t := copyTodo(*todo)
+	t.Status = statusOrDefault(t.Status)
+
+	return s.log(walRecord{Op: opPut, Todo: &t})
+}
+
+func (s *FileStore) Delete(ctx context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.todos.Get(ctx, id); err != nil {
+		return err
+	}
+
+	return s.log(walRecord{Op: opDelete, ID: id})
+}
+
+func (s *FileStore) Get(ctx context.Context, id int) (*schema.Todo, error) {
+	return s.todos.Get(ctx, id)
+}
+
+func (s *FileStore) GetAll(ctx context.Context) ([]schema.Todo, error) {
+	return s.todos.GetAll(ctx)
+}
+
+func (s *FileStore) List(ctx context.Context, opts ListOptions) ([]schema.Todo, int, error) {
+	return s.todos.List(ctx, opts)
+}
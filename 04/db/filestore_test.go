@@ -0,0 +1,174 @@
+package db
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cohhei/go-to-the-handson/04/schema"
+)
+
+func TestFileStore_Reopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "todo.json")
+
+	store := openFileStore(t, path)
+	for _, title := range []string{"title1", "title2", "title3"} {
+		if _, err := store.Insert(context.Background(), &schema.Todo{Title: title}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Delete(context.Background(), 3); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	// Changes after the snapshot are only in the log, as the process
+	// crashes before closing the store.
+	store = openFileStore(t, path)
+	due := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	updated := &schema.Todo{ID: 1, Title: "updated", DueDate: due}
+	if err := store.Update(context.Background(), updated); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(context.Background(), 2); err != nil {
+		t.Fatal(err)
+	}
+	crash(store)
+
+	reopened := openFileStore(t, path)
+
+	got, err := reopened.GetAll(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []schema.Todo{{ID: 1, Title: "updated", DueDate: due, Status: schema.StatusOpen, CreatedAt: updated.CreatedAt, UpdatedAt: updated.UpdatedAt, Version: 2}}
+	if !equal(got, want) {
+		t.Fatalf("Want: %v, Got: %v", want, got)
+	}
+
+	id, err := reopened.Insert(context.Background(), &schema.Todo{Title: "title4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 4 {
+		t.Fatalf("Want: %v, Got: %v", 4, id)
+	}
+	reopened.Close()
+
+	reopened = openFileStore(t, path)
+	defer reopened.Close()
+
+	if _, err := reopened.Get(context.Background(), 4); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileStore_Locked(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "todo.json")
+
+	store := openFileStore(t, path)
+	if _, err := OpenFileStore(path); err == nil {
+		t.Fatal("Want: an error, Got: nil")
+	}
+	store.Close()
+
+	store = openFileStore(t, path)
+	store.Close()
+}
+
+func TestFileStore_ReopenTx(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "todo.json")
+
+	store := openFileStore(t, path)
+
+	if _, err := store.Insert(context.Background(), &schema.Todo{Title: "title1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	inserted := &schema.Todo{Title: "title2"}
+	err := store.WithinTx(context.Background(), func(tx Repository) error {
+		if _, err := tx.Insert(context.Background(), inserted); err != nil {
+			return err
+		}
+		return tx.Delete(context.Background(), 1)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	crash(store)
+
+	reopened := openFileStore(t, path)
+	defer reopened.Close()
+
+	got, err := reopened.GetAll(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []schema.Todo{{ID: 2, Title: "title2", Status: schema.StatusOpen, CreatedAt: inserted.CreatedAt, UpdatedAt: inserted.UpdatedAt, Version: 1}}
+	if !equal(got, want) {
+		t.Fatalf("Want: %v, Got: %v", want, got)
+	}
+}
+
+func TestFileStore_TornLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "todo.json")
+
+	store := openFileStore(t, path)
+
+	if _, err := store.Insert(context.Background(), &schema.Todo{Title: "title1"}); err != nil {
+		t.Fatal(err)
+	}
+	crash(store)
+
+	f, err := os.OpenFile(path+".wal", os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(`{"op":"put","todo":{"id":2,"tit`); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	reopened := openFileStore(t, path)
+	defer reopened.Close()
+
+	got, err := reopened.GetAll(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != 1 || got[0].Title != "title1" {
+		t.Fatalf("Want: %v, Got: %v", "[title1]", got)
+	}
+}
+
+func TestFileStore_CorruptLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "todo.json")
+
+	if err := os.WriteFile(path+".wal", []byte("{\n"+`{"op":"delete","id":1}`+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := OpenFileStore(path); err == nil {
+		t.Fatal("Want: an error, Got: nil")
+	}
+}
+
+// crash closes the log of store without writing a snapshot, as if the
+// process died, which also releases the lock.
+func crash(store *FileStore) {
+	store.wal.Close()
+}
+
+func openFileStore(t *testing.T, path string) *FileStore {
+	store, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return store
+}
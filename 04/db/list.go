@@ -0,0 +1,95 @@
+package db
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cohhei/go-to-the-handson/04/schema"
+)
+
+// ListOptions narrows and orders the result of Repository.List. Zero values
+// mean no limit, no offset, no filter and ordering by ID.
+type ListOptions struct {
+	Limit     int
+	Offset    int
+	Sort      string
+	DueBefore time.Time
+	DueAfter  time.Time
+	Query     string
+}
+
+// sortColumns lists the fields accepted by ListOptions.Sort. A leading "-"
+// reverses the order.
+var sortColumns = map[string]string{
+	"id":       "id",
+	"title":    "title",
+	"due_date": "due_date",
+}
+
+func IsSortable(sort string) bool {
+	_, ok := sortColumns[strings.TrimPrefix(sort, "-")]
+	return ok
+}
+
+func sortField(sort string) (string, bool) {
+	if sort == "" {
+		return "id", false
+	}
+
+	return sortColumns[strings.TrimPrefix(sort, "-")], strings.HasPrefix(sort, "-")
+}
+
+// listTodos applies opts to todoList in memory the same way the SQL
+// repositories do, and returns the requested page with the filtered total.
+func listTodos(todoList []schema.Todo, opts ListOptions) ([]schema.Todo, int) {
+	query := strings.ToLower(opts.Query)
+
+	filtered := []schema.Todo{}
+	for _, t := range todoList {
+		if !opts.DueBefore.IsZero() && !t.DueDate.Before(opts.DueBefore) {
+			continue
+		}
+		if !opts.DueAfter.IsZero() && !t.DueDate.After(opts.DueAfter) {
+			continue
+		}
+		if query != "" &&
+			!strings.Contains(strings.ToLower(t.Title), query) &&
+			!strings.Contains(strings.ToLower(t.Note), query) {
+			continue
+		}
+		filtered = append(filtered, t)
+	}
+
+	field, desc := sortField(opts.Sort)
+	sort.SliceStable(filtered, func(i, j int) bool {
+		a, b := filtered[i], filtered[j]
+
+		switch field {
+		case "title":
+			if a.Title != b.Title {
+				return (a.Title < b.Title) != desc
+			}
+		case "due_date":
+			if !a.DueDate.Equal(b.DueDate) {
+				return a.DueDate.Before(b.DueDate) != desc
+			}
+		case "id":
+			return (a.ID < b.ID) != desc
+		}
+
+		return a.ID < b.ID
+	})
+
+	total := len(filtered)
+	if opts.Offset >= total {
+		return []schema.Todo{}, total
+	}
+	filtered = filtered[opts.Offset:]
+
+	if opts.Limit > 0 && opts.Limit < len(filtered) {
+		filtered = filtered[:opts.Limit]
+	}
+
+	return filtered, total
+}
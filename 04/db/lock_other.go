@@ -0,0 +1,11 @@
+//go:build !unix
+
+package db
+
+import "os"
+
+// lockFile does nothing where flock is not available, leaving it to the
+// caller to open a file from one FileStore at a time.
+func lockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,276 @@
+package db
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/cohhei/go-to-the-handson/04/schema"
+)
+
+// Memory is a Repository that keeps todos in a map. It is safe for
+// concurrent use and hands out copies, so callers cannot modify stored todos.
+type Memory struct {
+	mu     sync.RWMutex
+	todos  map[int]schema.Todo
+	lastID int
+}
+
+func NewMemory() *Memory {
+	return &Memory{todos: map[int]schema.Todo{}}
+}
+
+func (m *Memory) Close() {}
+
+func (m *Memory) Insert(ctx context.Context, todo *schema.Todo) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.insert(todo), nil
+}
+
+// insert stores todo under the next ID and returns it. The caller must hold
+// m.mu.
+func (m *Memory) insert(todo *schema.Todo) int {
+	m.lastID++
+	created(todo)
+	t := copyTodo(*todo)
+	t.ID = m.lastID
+	t.Status = statusOrDefault(t.Status)
+	m.todos[t.ID] = t
+
+	return t.ID
+}
+
+func (m *Memory) InsertMany(ctx context.Context, todos []schema.Todo) ([]int, error) {
+	return insertEach(ctx, m, todos)
+}
+
+func (m *Memory) DeleteMany(ctx context.Context, ids []int) ([]int, error) {
+	return deleteEach(ctx, m, ids)
+}
+
+func (m *Memory) Update(ctx context.Context, todo *schema.Todo) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.update(todo)
+}
+
+// update replaces the stored todo. The caller must hold m.mu.
+func (m *Memory) update(todo *schema.Todo) error {
+	stored, ok := m.todos[todo.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	if todo.Version != 0 && todo.Version != stored.Version {
+		stored = copyTodo(stored)
+		return &ConflictError{Current: &stored}
+	}
+
+	touch(todo)
+	todo.CreatedAt = stored.CreatedAt
+	todo.Version = stored.Version + 1
+	t := copyTodo(*todo)
+	t.Status = statusOrDefault(t.Status)
+	m.todos[t.ID] = t
+
+	return nil
+}
+
+func (m *Memory) Delete(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.delete(id)
+}
+
+// delete removes the todo with the given ID. The caller must hold m.mu.
+func (m *Memory) delete(id int) error {
+	if _, ok := m.todos[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.todos, id)
+
+	return nil
+}
+
+func (m *Memory) Get(ctx context.Context, id int) (*schema.Todo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.get(id)
+}
+
+// get returns a copy of the todo with the given ID. The caller must hold
+// m.mu.
+func (m *Memory) get(id int) (*schema.Todo, error) {
+	t, ok := m.todos[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	t = copyTodo(t)
+
+	return &t, nil
+}
+
+func (m *Memory) GetAll(ctx context.Context) ([]schema.Todo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.all(), nil
+}
+
+func (m *Memory) List(ctx context.Context, opts ListOptions) ([]schema.Todo, int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	todoList, total := listTodos(m.all(), opts)
+	return todoList, total, nil
+}
+
+// WithinTx calls fn with a repository that changes the todos of m in place
+// and remembers how to undo each change, so that the changes are undone if
+// fn fails or panics. m is locked meanwhile, so transactions run one at a
+// time and never conflict.
+func (m *Memory) WithinTx(ctx context.Context, fn func(repository Repository) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return (&memoryTx{m: m}).WithinTx(ctx, fn)
+}
+
+// memoryTx is the Repository of a Memory transaction. Its caller holds m.mu.
+type memoryTx struct {
+	m    *Memory
+	undo []memoryUndo
+}
+
+// memoryUndo holds a todo and the last ID as they were before the
+// transaction changed them. todo is nil if the todo did not exist.
+type memoryUndo struct {
+	id     int
+	todo   *schema.Todo
+	lastID int
+}
+
+// save remembers the todo with the given ID as it is now.
+func (tx *memoryTx) save(id int) {
+	u := memoryUndo{id: id, lastID: tx.m.lastID}
+	if t, ok := tx.m.todos[id]; ok {
+		u.todo = &t
+	}
+	tx.undo = append(tx.undo, u)
+}
+
+// rollback undoes the changes saved after the first n, latest first.
+func (tx *memoryTx) rollback(n int) {
+	for i := len(tx.undo) - 1; i >= n; i-- {
+		u := tx.undo[i]
+		if u.todo == nil {
+			delete(tx.m.todos, u.id)
+		} else {
+			tx.m.todos[u.id] = *u.todo
+		}
+		tx.m.lastID = u.lastID
+	}
+	tx.undo = tx.undo[:n]
+}
+
+func (tx *memoryTx) Close() {}
+
+func (tx *memoryTx) Insert(ctx context.Context, todo *schema.Todo) (int, error) {
+	tx.save(tx.m.lastID + 1)
+	return tx.m.insert(todo), nil
+}
+
+func (tx *memoryTx) InsertMany(ctx context.Context, todos []schema.Todo) ([]int, error) {
+	return insertEach(ctx, tx, todos)
+}
+
+func (tx *memoryTx) DeleteMany(ctx context.Context, ids []int) ([]int, error) {
+	return deleteEach(ctx, tx, ids)
+}
+
+func (tx *memoryTx) Update(ctx context.Context, todo *schema.Todo) error {
+	tx.save(todo.ID)
+	return tx.m.update(todo)
+}
+
+func (tx *memoryTx) Delete(ctx context.Context, id int) error {
+	tx.save(id)
+	return tx.m.delete(id)
+}
+
+func (tx *memoryTx) Get(ctx context.Context, id int) (*schema.Todo, error) {
+	return tx.m.get(id)
+}
+
+func (tx *memoryTx) GetAll(ctx context.Context) ([]schema.Todo, error) {
+	return tx.m.all(), nil
+}
+
+func (tx *memoryTx) List(ctx context.Context, opts ListOptions) ([]schema.Todo, int, error) {
+	todoList, total := listTodos(tx.m.all(), opts)
+	return todoList, total, nil
+}
+
+// WithinTx calls fn with tx and undoes the changes fn made if it fails or
+// panics, like a savepoint.
+func (tx *memoryTx) WithinTx(ctx context.Context, fn func(repository Repository) error) error {
+	n := len(tx.undo)
+	committed := false
+	defer func() {
+		if !committed {
+			tx.rollback(n)
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	committed = true
+
+	return nil
+}
+
+// put stores t as is, keeping IDs handed out later above t.ID.
+func (m *Memory) put(t schema.Todo) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.todos[t.ID] = copyTodo(t)
+	if t.ID > m.lastID {
+		m.lastID = t.ID
+	}
+}
+
+func (m *Memory) remove(id int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.todos, id)
+}
+
+// all returns copies of every todo ordered by ID. The caller must hold m.mu.
+func (m *Memory) all() []schema.Todo {
+	todoList := make([]schema.Todo, 0, len(m.todos))
+	for _, t := range m.todos {
+		todoList = append(todoList, copyTodo(t))
+	}
+
+	sort.Slice(todoList, func(i, j int) bool {
+		return todoList[i].ID < todoList[j].ID
+	})
+
+	return todoList
+}
+
+func copyTodo(t schema.Todo) schema.Todo {
+	if t.CompletedAt != nil {
+		completedAt := *t.CompletedAt
+		t.CompletedAt = &completedAt
+	}
+
+	return t
+}
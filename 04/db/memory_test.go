@@ -0,0 +1,101 @@
+package db
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cohhei/go-to-the-handson/04/schema"
+)
+
+func TestMemory(t *testing.T) {
+	ctx := context.Background()
+	memory := NewMemory()
+
+	completedAt := time.Date(2000, 1, 2, 0, 0, 0, 0, time.UTC)
+	todo := &schema.Todo{
+		Title:       "title1",
+		DueDate:     time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+		Status:      schema.StatusDone,
+		CompletedAt: &completedAt,
+	}
+
+	id, err := memory.Insert(ctx, todo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := memory.Get(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := *todo
+	want.ID = id
+	if !equal(got, &want) {
+		t.Fatalf("Want: %v, Got: %v", want, got)
+	}
+
+	// Changing a returned todo must not change the stored one.
+	got.Title = "changed"
+	*got.CompletedAt = time.Time{}
+
+	again, err := memory.Get(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equal(again, &want) {
+		t.Fatalf("Want: %v, Got: %v", want, again)
+	}
+
+	if err := memory.Delete(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := memory.Get(ctx, id); err != ErrNotFound {
+		t.Fatalf("Want: %v, Got: %v", ErrNotFound, err)
+	}
+
+	next, err := memory.Insert(ctx, todo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next != id+1 {
+		t.Fatalf("IDs must not be reused, Want: %v, Got: %v", id+1, next)
+	}
+}
+
+func TestMemory_Concurrent(t *testing.T) {
+	ctx := context.Background()
+	memory := NewMemory()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := memory.Insert(ctx, &schema.Todo{Title: "title"}); err != nil {
+				t.Error(err)
+			}
+			if _, _, err := memory.List(ctx, ListOptions{Limit: 10}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	got, err := memory.GetAll(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != 50 || got[0].ID != 1 || got[49].ID != 50 {
+		t.Fatalf("Want IDs 1 to 50, Got: %v", got)
+	}
+}
+
+func equal(got interface{}, want interface{}) bool {
+	return reflect.DeepEqual(got, want)
+}
@@ -1,101 +1,25 @@
-package db
+package db_test
 
 import (
-	"reflect"
+	"context"
 	"testing"
 	"time"
 
-	"github.com/cohhei/go-to-the-handson/04/schema"
-	"github.com/cohhei/go-to-the-handson/04/testdb"
-	_ "github.com/lib/pq"
+	"github.com/cohhei/go-to-the-handson/04/db"
+	"github.com/cohhei/go-to-the-handson/04/retry"
 )
 
-func TestPostgres_Insert(t *testing.T) {
-	postgres := &Postgres{testdb.Setup()}
-	defer postgres.Close()
+func TestConnectPostgresWithRetryCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
 
-	todo := &schema.Todo{
-		Title:   "title1",
-		Note:    "note1",
-		DueDate: time.Date(2000, 1, 1, 0, 0, 0, 0, time.Local),
+	start := time.Now()
+	// 10.255.255.1 drops packets, so only the context can end the attempts.
+	_, err := db.ConnectPostgresWithRetry(ctx, "postgres://10.255.255.1/todo?sslmode=disable", retry.DefaultBackoff)
+	if err == nil {
+		t.Fatal("Want: an error, Got: nil")
 	}
-
-	got, err := postgres.Insert(todo)
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	want := 1
-
-	if got != want {
-		t.Fatal(err)
-	}
-}
-
-func TestPostgres_GetAll(t *testing.T) {
-	postgres := &Postgres{testdb.Setup()}
-	defer postgres.Close()
-
-	todo := &schema.Todo{
-		Title:   "title1",
-		Note:    "note1",
-		DueDate: time.Date(2000, 1, 1, 0, 0, 0, 0, time.Local),
-	}
-
-	_, err := postgres.Insert(todo)
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	got, err := postgres.GetAll()
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	want := []schema.Todo{
-		{
-			ID:      1,
-			Title:   "title1",
-			Note:    "note1",
-			DueDate: time.Date(2000, 1, 1, 0, 0, 0, 0, time.Local),
-		},
-	}
-
-	if equal(got, want) {
-		t.Fatalf("Want: %v, Got: %v", want, got)
-	}
-}
-
-func TestPostgres_Delete(t *testing.T) {
-	postgres := &Postgres{testdb.Setup()}
-	defer postgres.Close()
-
-	todo := &schema.Todo{
-		Title:   "title1",
-		Note:    "note1",
-		DueDate: time.Date(2000, 1, 1, 0, 0, 0, 0, time.Local),
-	}
-
-	id, err := postgres.Insert(todo)
-	if err != nil {
-		t.Fatal(err)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Want: less than 1s, Got: %v", elapsed)
 	}
-
-	err = postgres.Delete(id)
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	got, err := postgres.GetAll()
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	if len(got) > 0 {
-		t.Fatal("The record is not deleted.")
-	}
-}
-
-func equal(got interface{}, want interface{}) bool {
-	return reflect.DeepEqual(got, want)
 }
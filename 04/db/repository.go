@@ -2,39 +2,158 @@ package db
 
 import (
 	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"time"
 
 	"github.com/cohhei/go-to-the-handson/04/schema"
 )
 
-const keyRepository = "Repository"
+var ErrNotFound = errors.New("todo not found")
 
+// ConflictError is returned by Update when the todo has been written since
+// the version being updated was read. Current is the todo as stored.
+type ConflictError struct {
+	Current *schema.Todo
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("todo %d has been modified, its current version is %d", e.Current.ID, e.Current.Version)
+}
+
+// Repository stores todos. Insert, Update and the batch operations set the
+// CreatedAt, UpdatedAt and Version fields of the todos they are given.
 type Repository interface {
 	Close()
-	Insert(todo *schema.Todo) (int, error)
-	Delete(id int) error
-	GetAll() ([]schema.Todo, error)
+	Insert(ctx context.Context, todo *schema.Todo) (int, error)
+
+	// Update replaces the stored todo if its version is todo.Version, and
+	// returns a *ConflictError otherwise. A zero todo.Version replaces any
+	// version.
+	Update(ctx context.Context, todo *schema.Todo) error
+
+	Delete(ctx context.Context, id int) error
+	Get(ctx context.Context, id int) (*schema.Todo, error)
+	GetAll(ctx context.Context) ([]schema.Todo, error)
+	List(ctx context.Context, opts ListOptions) ([]schema.Todo, int, error)
+
+	// InsertMany stores todos in one transaction and returns their IDs in
+	// the same order.
+	InsertMany(ctx context.Context, todos []schema.Todo) ([]int, error)
+
+	// DeleteMany deletes the todos with the given IDs in one transaction
+	// and returns, in the given order, the IDs of the todos that existed.
+	DeleteMany(ctx context.Context, ids []int) ([]int, error)
+
+	// WithinTx calls fn with a repository whose operations are applied
+	// together if fn returns nil and not at all if it fails or panics. fn
+	// must only use the repository it is given, and may be called more than
+	// once when the transaction has to be retried. Called on a repository
+	// bound to a transaction, WithinTx works like a savepoint of it.
+	WithinTx(ctx context.Context, fn func(repository Repository) error) error
 }
 
-func SetRepository(ctx context.Context, repository Repository) context.Context {
-	return context.WithValue(ctx, keyRepository, repository)
+// IsUnavailable reports whether err means that the database could not be
+// reached or could not serve the request, rather than that it refused it.
+func IsUnavailable(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	return isPostgresUnavailable(err)
 }
 
-func Close(ctx context.Context) {
-	getRepository(ctx).Close()
+// statusOrDefault mirrors the default of the status column for todos that
+// are stored without one.
+func statusOrDefault(status schema.Status) schema.Status {
+	if status == "" {
+		return schema.StatusOpen
+	}
+	return status
+}
+
+// conflict returns ErrNotFound if the todo with the given ID does not exist
+// in r and a *ConflictError holding it otherwise, for Update to report why
+// no row matched.
+func conflict(ctx context.Context, r Repository, id int) error {
+	current, err := r.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	return &ConflictError{Current: current}
 }
 
-func Insert(ctx context.Context, todo *schema.Todo) (int, error) {
-	return getRepository(ctx).Insert(todo)
+// Now returns the current time in UTC, truncated to the precision of
+// Postgres timestamps so that it reads back unchanged.
+func Now() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
 }
 
-func Delete(ctx context.Context, id int) error {
-	return getRepository(ctx).Delete(id)
+// touch sets todo.UpdatedAt to the current time.
+func touch(todo *schema.Todo) {
+	todo.UpdatedAt = Now()
 }
 
-func GetAll(ctx context.Context) ([]schema.Todo, error) {
-	return getRepository(ctx).GetAll()
+// created sets the fields that the repository maintains on a todo about to
+// be inserted.
+func created(todo *schema.Todo) {
+	touch(todo)
+	todo.CreatedAt = todo.UpdatedAt
+	todo.Version = 1
 }
 
-func getRepository(ctx context.Context) Repository {
-	return ctx.Value(keyRepository).(Repository)
+// insertEach implements InsertMany by inserting todos one by one within a
+// transaction of r.
+func insertEach(ctx context.Context, r Repository, todos []schema.Todo) ([]int, error) {
+	var ids []int
+	err := r.WithinTx(ctx, func(tx Repository) error {
+		ids = make([]int, 0, len(todos))
+		for i := range todos {
+			id, err := tx.Insert(ctx, &todos[i])
+			if err != nil {
+				return err
+			}
+			ids = append(ids, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// deleteEach implements DeleteMany by deleting todos one by one within a
+// transaction of r.
+func deleteEach(ctx context.Context, r Repository, ids []int) ([]int, error) {
+	var deleted []int
+	err := r.WithinTx(ctx, func(tx Repository) error {
+		deleted = make([]int, 0, len(ids))
+		for _, id := range ids {
+			err := tx.Delete(ctx, id)
+			if err == ErrNotFound {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			deleted = append(deleted, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return deleted, nil
 }
@@ -1,6 +1,7 @@
 package db
 
 import (
+	"context"
 	"reflect"
 	"testing"
 	"time"
@@ -19,7 +20,7 @@ func TestInsert(t *testing.T) {
 
 	todo := &schema.Todo{}
 
-	got, err := sample.Insert(todo)
+	got, err := sample.Insert(context.Background(), todo)
 	if err != nil {
 		t.Error(err)
 	}
@@ -29,41 +30,97 @@ func TestInsert(t *testing.T) {
 	}
 }
 
+func TestUpdate(t *testing.T) {
+	sample := Sample{}
+
+	if err := sample.Update(context.Background(), &schema.Todo{ID: 1}); err != nil {
+		t.Error(err)
+	}
+
+	if err := sample.Update(context.Background(), &schema.Todo{ID: 100}); err != ErrNotFound {
+		t.Fatalf("Want: %v, Got: %v", ErrNotFound, err)
+	}
+}
+
 func TestDelete(t *testing.T) {
 	sample := Sample{}
 
-	err := sample.Delete(1)
+	err := sample.Delete(context.Background(), 1)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if err := sample.Delete(context.Background(), 100); err != ErrNotFound {
+		t.Fatalf("Want: %v, Got: %v", ErrNotFound, err)
+	}
+}
+
+func TestGet(t *testing.T) {
+	sample := Sample{}
+
+	got, err := sample.Get(context.Background(), 1)
 	if err != nil {
 		t.Error(err)
 	}
+
+	want := &schema.Todo{
+		ID:        1,
+		Title:     "Do dishes",
+		Note:      "",
+		DueDate:   time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+		Status:    schema.StatusOpen,
+		CreatedAt: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+		UpdatedAt: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+		Version:   1,
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Want: %v, Got: %v\n", want, got)
+	}
+
+	if _, err := sample.Get(context.Background(), 100); err != ErrNotFound {
+		t.Fatalf("Want: %v, Got: %v", ErrNotFound, err)
+	}
 }
 
 func TestGetAll(t *testing.T) {
 	sample := Sample{}
 
-	got, err := sample.GetAll()
+	got, err := sample.GetAll(context.Background())
 	if err != nil {
 		t.Error(err)
 	}
 
 	want := []schema.Todo{
 		{
-			ID:      1,
-			Title:   "Do dishes",
-			Note:    "",
-			DueDate: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+			ID:        1,
+			Title:     "Do dishes",
+			Note:      "",
+			DueDate:   time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+			Status:    schema.StatusOpen,
+			CreatedAt: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+			UpdatedAt: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+			Version:   1,
 		},
 		{
-			ID:      2,
-			Title:   "Do homework",
-			Note:    "",
-			DueDate: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+			ID:        2,
+			Title:     "Do homework",
+			Note:      "",
+			DueDate:   time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+			Status:    schema.StatusOpen,
+			CreatedAt: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+			UpdatedAt: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+			Version:   1,
 		},
 		{
-			ID:      2,
-			Title:   "Twitter",
-			Note:    "",
-			DueDate: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+			ID:        3,
+			Title:     "Twitter",
+			Note:      "",
+			DueDate:   time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+			Status:    schema.StatusOpen,
+			CreatedAt: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+			UpdatedAt: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+			Version:   1,
 		},
 	}
 
@@ -71,3 +128,38 @@ func TestGetAll(t *testing.T) {
 		t.Fatalf("Want: %v, Got: %v\n", want, got)
 	}
 }
+
+func TestList(t *testing.T) {
+	sample := Sample{}
+
+	tests := []struct {
+		opts    ListOptions
+		wantIDs []int
+		total   int
+	}{
+		{ListOptions{}, []int{1, 2, 3}, 3},
+		{ListOptions{Limit: 2}, []int{1, 2}, 3},
+		{ListOptions{Limit: 2, Offset: 2}, []int{3}, 3},
+		{ListOptions{Offset: 5}, []int{}, 3},
+		{ListOptions{Sort: "-title"}, []int{3, 2, 1}, 3},
+		{ListOptions{Query: "do "}, []int{1, 2}, 2},
+		{ListOptions{DueAfter: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)}, []int{}, 0},
+		{ListOptions{DueBefore: time.Date(2000, 1, 2, 0, 0, 0, 0, time.UTC)}, []int{1, 2, 3}, 3},
+	}
+
+	for _, tt := range tests {
+		got, total, err := sample.List(context.Background(), tt.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		gotIDs := []int{}
+		for _, todo := range got {
+			gotIDs = append(gotIDs, todo.ID)
+		}
+
+		if !reflect.DeepEqual(gotIDs, tt.wantIDs) || total != tt.total {
+			t.Fatalf("%+v: Want: %v (%d), Got: %v (%d)", tt.opts, tt.wantIDs, tt.total, gotIDs, total)
+		}
+	}
+}
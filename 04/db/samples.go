@@ -1,6 +1,7 @@
 package db
 
 import (
+	"context"
 	"time"
 
 	"github.com/cohhei/go-to-the-handson/04/schema"
@@ -10,33 +11,89 @@ type Sample struct{}
 
 func (s *Sample) Close() {}
 
-func (s *Sample) Insert(todo *schema.Todo) (int, error) {
+// WithinTx calls fn with s, since nothing can be written to s anyway.
+func (s *Sample) WithinTx(ctx context.Context, fn func(repository Repository) error) error {
+	return fn(s)
+}
+
+func (s *Sample) Insert(ctx context.Context, todo *schema.Todo) (int, error) {
 	return 0, nil
 }
 
-func (s *Sample) Delete(id int) error {
-	return nil
+func (s *Sample) InsertMany(ctx context.Context, todos []schema.Todo) ([]int, error) {
+	return insertEach(ctx, s, todos)
+}
+
+func (s *Sample) DeleteMany(ctx context.Context, ids []int) ([]int, error) {
+	return deleteEach(ctx, s, ids)
+}
+
+func (s *Sample) Update(ctx context.Context, todo *schema.Todo) error {
+	_, err := s.Get(ctx, todo.ID)
+	return err
+}
+
+func (s *Sample) Delete(ctx context.Context, id int) error {
+	_, err := s.Get(ctx, id)
+	return err
+}
+
+func (s *Sample) Get(ctx context.Context, id int) (*schema.Todo, error) {
+	todoList, err := s.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, t := range todoList {
+		if t.ID == id {
+			return &t, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+func (s *Sample) List(ctx context.Context, opts ListOptions) ([]schema.Todo, int, error) {
+	todoList, err := s.GetAll(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	todoList, total := listTodos(todoList, opts)
+	return todoList, total, nil
 }
 
-func (s *Sample) GetAll() ([]schema.Todo, error) {
+func (s *Sample) GetAll(ctx context.Context) ([]schema.Todo, error) {
 	todoList := []schema.Todo{
 		{
-			ID:      1,
-			Title:   "Do dishes",
-			Note:    "",
-			DueDate: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+			ID:        1,
+			Title:     "Do dishes",
+			Note:      "",
+			DueDate:   time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+			Status:    schema.StatusOpen,
+			CreatedAt: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+			UpdatedAt: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+			Version:   1,
 		},
 		{
-			ID:      2,
-			Title:   "Do homework",
-			Note:    "",
-			DueDate: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+			ID:        2,
+			Title:     "Do homework",
+			Note:      "",
+			DueDate:   time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+			Status:    schema.StatusOpen,
+			CreatedAt: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+			UpdatedAt: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+			Version:   1,
 		},
 		{
-			ID:      2,
-			Title:   "Twitter",
-			Note:    "",
-			DueDate: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+			ID:        3,
+			Title:     "Twitter",
+			Note:      "",
+			DueDate:   time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+			Status:    schema.StatusOpen,
+			CreatedAt: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+			UpdatedAt: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+			Version:   1,
 		},
 	}
 
@@ -0,0 +1,184 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/cohhei/go-to-the-handson/04/schema"
+)
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// Querier runs statements. It is satisfied by *sql.DB, *sql.Conn and
+// *sql.Tx, so a repository can work inside a transaction.
+type Querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+type beginner interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// runTx runs fn in a transaction begun on b, which is committed if fn
+// returns nil and rolled back if it fails or panics.
+func runTx(ctx context.Context, b beginner, opts *sql.TxOptions, fn func(tx *sql.Tx) error) error {
+	tx, err := b.BeginTx(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// runSavepoint runs fn within a savepoint of the transaction q is bound to,
+// which is released if fn returns nil and rolled back to if fn fails or
+// panics, so that a nested transaction leaves nothing behind when it fails.
+func runSavepoint(ctx context.Context, q Querier, fn func() error) error {
+	if _, err := q.ExecContext(ctx, "SAVEPOINT within_tx"); err != nil {
+		return err
+	}
+
+	// The rollback has to run even when ctx is the reason fn failed.
+	rollback := func() {
+		q.ExecContext(context.WithoutCancel(ctx), "ROLLBACK TO SAVEPOINT within_tx")
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(); err != nil {
+		rollback()
+		return err
+	}
+
+	_, err := q.ExecContext(ctx, "RELEASE SAVEPOINT within_tx")
+	return err
+}
+
+// todoColumns lists the columns of the todo table in the order scanTodo
+// reads them.
+const todoColumns = "id, title, note, due_date, status, completed_at, created_at, updated_at, version"
+
+func scanTodo(s scanner, t *schema.Todo) error {
+	return s.Scan(&t.ID, &t.Title, &t.Note, &t.DueDate, &t.Status, &t.CompletedAt, &t.CreatedAt, &t.UpdatedAt, &t.Version)
+}
+
+func queryTodos(ctx context.Context, q Querier, query string, args ...interface{}) ([]schema.Todo, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	todoList := []schema.Todo{}
+	for rows.Next() {
+		var t schema.Todo
+		if err := scanTodo(rows, &t); err != nil {
+			return nil, err
+		}
+		todoList = append(todoList, t)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return todoList, nil
+}
+
+// dialect holds the SQL that differs between the SQL repositories.
+type dialect struct {
+	// param prefixes the number of a numbered parameter.
+	param string
+	// like is the case-insensitive pattern operator.
+	like string
+	// noLimit is the LIMIT value that returns every row.
+	noLimit string
+}
+
+var (
+	postgresDialect = dialect{param: "$", like: "ILIKE", noLimit: "ALL"}
+	sqliteDialect   = dialect{param: "?", like: "LIKE", noLimit: "-1"}
+)
+
+// listQueries builds the statements counting and selecting the todos matched
+// by opts, with their arguments.
+func listQueries(opts ListOptions, d dialect) (string, []interface{}, string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	if !opts.DueBefore.IsZero() {
+		args = append(args, opts.DueBefore.UTC())
+		conds = append(conds, fmt.Sprintf("due_date < %s%d", d.param, len(args)))
+	}
+	if !opts.DueAfter.IsZero() {
+		args = append(args, opts.DueAfter.UTC())
+		conds = append(conds, fmt.Sprintf("due_date > %s%d", d.param, len(args)))
+	}
+	if opts.Query != "" {
+		args = append(args, "%"+escapeLike(opts.Query)+"%")
+		n := len(args)
+		conds = append(conds, fmt.Sprintf(`(title %s %s%d ESCAPE '\' OR note %s %s%d ESCAPE '\')`, d.like, d.param, n, d.like, d.param, n))
+	}
+
+	where := ""
+	if len(conds) > 0 {
+		where = "WHERE " + strings.Join(conds, " AND ")
+	}
+	count := "SELECT count(*) FROM todo " + where
+	countArgs := args
+
+	field, desc := sortField(opts.Sort)
+	order := field
+	if desc {
+		order += " DESC"
+	}
+	if field != "id" {
+		order += ", id"
+	}
+
+	limit := d.noLimit
+	args = append(args[:len(args):len(args)], opts.Offset)
+	offset := fmt.Sprintf("%s%d", d.param, len(args))
+	if opts.Limit > 0 {
+		args = append(args, opts.Limit)
+		limit = fmt.Sprintf("%s%d", d.param, len(args))
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM todo
+		%s
+		ORDER BY %s
+		LIMIT %s OFFSET %s
+	`, todoColumns, where, order, limit, offset)
+
+	return count, countArgs, query, args
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
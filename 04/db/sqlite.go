@@ -0,0 +1,209 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"time"
+
+	"github.com/cohhei/go-to-the-handson/04/migrations"
+	"github.com/cohhei/go-to-the-handson/04/schema"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+type SQLite struct {
+	DB Querier
+}
+
+// ConnectSQLite opens the database file at path, creating it if needed, and
+// applies the pending SQLite migrations.
+func ConnectSQLite(path string) (*SQLite, error) {
+	db, err := sql.Open("sqlite3", "file:"+path+"?_busy_timeout=5000&_journal_mode=WAL")
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite allows a single writer, and an in-memory database only lives
+	// as long as its connection.
+	db.SetMaxOpenConns(1)
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	migrator, err := migrations.NewSQLite(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if _, err := migrator.Up(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &SQLite{db}, nil
+}
+
+func (s *SQLite) Close() {
+	if c, ok := s.DB.(io.Closer); ok {
+		c.Close()
+	}
+}
+
+func (s *SQLite) Ping(ctx context.Context) error {
+	if pinger, ok := s.DB.(interface{ PingContext(context.Context) error }); ok {
+		return pinger.PingContext(ctx)
+	}
+
+	return nil
+}
+
+// WithinTx runs fn in a transaction. SQLite transactions are serializable
+// and the single connection never conflicts with itself, so they are not
+// retried. When s is already bound to a transaction, fn runs within a
+// savepoint of it.
+func (s *SQLite) WithinTx(ctx context.Context, fn func(repository Repository) error) error {
+	b, ok := s.DB.(beginner)
+	if !ok {
+		return runSavepoint(ctx, s.DB, func() error {
+			return fn(s)
+		})
+	}
+
+	return runTx(ctx, b, nil, func(tx *sql.Tx) error {
+		return fn(&SQLite{tx})
+	})
+}
+
+func (s *SQLite) Insert(ctx context.Context, todo *schema.Todo) (int, error) {
+	query := `
+		INSERT INTO todo (title, note, due_date, status, completed_at, created_at, updated_at, version)
+		VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8);
+	`
+
+	created(todo)
+
+	res, err := s.DB.ExecContext(ctx, query, todo.Title, todo.Note, todo.DueDate.UTC(), statusOrDefault(todo.Status), utc(todo.CompletedAt), todo.CreatedAt, todo.UpdatedAt, todo.Version)
+	if err != nil {
+		return -1, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return -1, err
+	}
+
+	return int(id), nil
+}
+
+func (s *SQLite) InsertMany(ctx context.Context, todos []schema.Todo) ([]int, error) {
+	return insertEach(ctx, s, todos)
+}
+
+func (s *SQLite) DeleteMany(ctx context.Context, ids []int) ([]int, error) {
+	return deleteEach(ctx, s, ids)
+}
+
+func (s *SQLite) Update(ctx context.Context, todo *schema.Todo) error {
+	query := `
+		UPDATE todo
+		SET title = ?2, note = ?3, due_date = ?4, status = ?5, completed_at = ?6, updated_at = ?7, version = version + 1
+		WHERE id = ?1 AND (?8 = 0 OR version = ?8)
+		RETURNING created_at, version;
+	`
+
+	t := *todo
+	touch(&t)
+
+	err := s.DB.QueryRowContext(ctx, query, t.ID, t.Title, t.Note, t.DueDate.UTC(), statusOrDefault(t.Status), utc(t.CompletedAt), t.UpdatedAt, t.Version).Scan(&t.CreatedAt, &t.Version)
+	if err == sql.ErrNoRows {
+		return conflict(ctx, s, t.ID)
+	}
+	if err != nil {
+		return err
+	}
+
+	*todo = t
+	return nil
+}
+
+func (s *SQLite) Delete(ctx context.Context, id int) error {
+	query := `
+		DELETE FROM todo
+		WHERE id = ?1;
+	`
+
+	res, err := s.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *SQLite) Get(ctx context.Context, id int) (*schema.Todo, error) {
+	query := `
+		SELECT ` + todoColumns + `
+		FROM todo
+		WHERE id = ?1;
+	`
+
+	var t schema.Todo
+	err := scanTodo(s.DB.QueryRowContext(ctx, query, id), &t)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+func (s *SQLite) GetAll(ctx context.Context) ([]schema.Todo, error) {
+	query := `
+		SELECT ` + todoColumns + `
+		FROM todo
+		ORDER BY id;
+	`
+
+	return queryTodos(ctx, s.DB, query)
+}
+
+func (s *SQLite) List(ctx context.Context, opts ListOptions) ([]schema.Todo, int, error) {
+	count, countArgs, query, args := listQueries(opts, sqliteDialect)
+
+	var total int
+	if err := s.DB.QueryRowContext(ctx, count, countArgs...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	todoList, err := queryTodos(ctx, s.DB, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return todoList, total, nil
+}
+
+// utc returns t in UTC. SQLite stores times as text and compares them as
+// strings, so times are only comparable when they share a zone.
+func utc(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+
+	u := t.UTC()
+	return &u
+}
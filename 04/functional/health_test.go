@@ -0,0 +1,61 @@
+package functional
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cohhei/go-to-the-handson/04/handler"
+)
+
+func TestReadiness(t *testing.T) {
+	readiness := handler.NewReadiness()
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/healthz", http.StatusOK},
+		{"/readyz", http.StatusServiceUnavailable},
+		{"/samples", http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		if got := serve(readiness, tt.path); got != tt.want {
+			t.Fatalf("%s before ready: Want: %v, Got: %v", tt.path, tt.want, got)
+		}
+	}
+
+	var checkErr error
+	readiness.SetReady(setupServer(nil), func(ctx context.Context) error {
+		return checkErr
+	})
+
+	tests = []struct {
+		path string
+		want int
+	}{
+		{"/healthz", http.StatusOK},
+		{"/readyz", http.StatusOK},
+		{"/samples", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if got := serve(readiness, tt.path); got != tt.want {
+			t.Fatalf("%s after ready: Want: %v, Got: %v", tt.path, tt.want, got)
+		}
+	}
+
+	checkErr = errors.New("connection refused")
+	if got := serve(readiness, "/readyz"); got != http.StatusServiceUnavailable {
+		t.Fatalf("/readyz with failing check: Want: %v, Got: %v", http.StatusServiceUnavailable, got)
+	}
+}
+
+func serve(h http.Handler, path string) int {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	return rec.Code
+}
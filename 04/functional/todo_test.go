@@ -2,9 +2,14 @@ package functional
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
@@ -13,6 +18,7 @@ import (
 	"github.com/cohhei/go-to-the-handson/04/db"
 	"github.com/cohhei/go-to-the-handson/04/handler"
 	"github.com/cohhei/go-to-the-handson/04/schema"
+	"github.com/cohhei/go-to-the-handson/04/service"
 	"github.com/cohhei/go-to-the-handson/04/testdb"
 )
 
@@ -29,7 +35,7 @@ func TestGetSamples(t *testing.T) {
 
 	got := strings.TrimSpace(rec.Body.String())
 
-	want := `[{"id":1,"title":"Do dishes","note":"","due_date":"2000-01-01T00:00:00Z"},{"id":2,"title":"Do homework","note":"","due_date":"2000-01-01T00:00:00Z"},{"id":2,"title":"Twitter","note":"","due_date":"2000-01-01T00:00:00Z"}]`
+	want := `[{"id":1,"title":"Do dishes","note":"","due_date":"2000-01-01T00:00:00Z","status":"open","created_at":"2000-01-01T00:00:00Z","updated_at":"2000-01-01T00:00:00Z","version":1},{"id":2,"title":"Do homework","note":"","due_date":"2000-01-01T00:00:00Z","status":"open","created_at":"2000-01-01T00:00:00Z","updated_at":"2000-01-01T00:00:00Z","version":1},{"id":3,"title":"Twitter","note":"","due_date":"2000-01-01T00:00:00Z","status":"open","created_at":"2000-01-01T00:00:00Z","updated_at":"2000-01-01T00:00:00Z","version":1}]`
 
 	if got != want {
 		t.Fatalf("Want: %v, Got: %v", want, got)
@@ -37,15 +43,15 @@ func TestGetSamples(t *testing.T) {
 }
 
 func TestGetAllTodo(t *testing.T) {
-	postgres := &db.Postgres{testdb.Setup()}
-	testServer := setupServer(postgres)
+	repository := newRepository(t)
+	testServer := setupServer(repository)
 
 	todo := &schema.Todo{
 		Title:   "My Task1",
 		DueDate: time.Date(2000, 1, 1, 0, 0, 0, 0, time.Local),
 	}
 
-	_, err := postgres.Insert(todo)
+	_, err := repository.Insert(context.Background(), todo)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -60,7 +66,200 @@ func TestGetAllTodo(t *testing.T) {
 
 	got := strings.TrimSpace(rec.Body.String())
 
-	want := `[{"id":1,"title":"My Task1","note":"","due_date":"2000-01-01T00:00:00+09:00"}]`
+	dueDate, err := json.Marshal(todo.DueDate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	updatedAt, err := json.Marshal(todo.UpdatedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := fmt.Sprintf(`[{"id":1,"title":"My Task1","note":"","due_date":%s,"status":"open","created_at":%s,"updated_at":%s,"version":1}]`, dueDate, updatedAt, updatedAt)
+
+	if got != want {
+		t.Fatalf("Want: %v, Got: %v", want, got)
+	}
+}
+
+func TestGetAllTodoNegotiation(t *testing.T) {
+	repository := newRepository(t)
+	testServer := setupServer(repository)
+
+	todo := &schema.Todo{
+		Title:   "My, Task1",
+		DueDate: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	if _, err := repository.Insert(context.Background(), todo); err != nil {
+		t.Fatal(err)
+	}
+	updatedAt := todo.UpdatedAt.UTC().Format(time.RFC3339Nano)
+	csv := "id,title,note,due_date,status,completed_at,created_at,updated_at,version\n1,\"My, Task1\",,2000-01-01T00:00:00Z,open,," + updatedAt + "," + updatedAt + ",1"
+
+	tests := []struct {
+		accept      string
+		code        int
+		contentType string
+		want        string
+	}{
+		{"application/x-ndjson", http.StatusOK, "application/x-ndjson", `{"id":1,"title":"My, Task1","note":"","due_date":"2000-01-01T00:00:00Z","status":"open","created_at":"` + updatedAt + `","updated_at":"` + updatedAt + `","version":1}`},
+		{"text/csv, application/json;q=0.5", http.StatusOK, "text/csv; charset=utf-8", csv},
+		{"text/*;q=0.9, */*;q=0.1", http.StatusOK, "text/csv; charset=utf-8", csv},
+		{"image/png", http.StatusNotAcceptable, "application/problem+json", ""},
+	}
+
+	for _, tt := range tests {
+		req, err := http.NewRequest(http.MethodGet, "http://localhost:8080/todo", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Accept", tt.accept)
+
+		rec := httptest.NewRecorder()
+		testServer.ServeHTTP(rec, req)
+
+		if rec.Code != tt.code {
+			t.Fatalf("%s: Want: %v, Got: %v", tt.accept, tt.code, rec.Code)
+		}
+		if got := rec.Header().Get("Content-Type"); got != tt.contentType {
+			t.Fatalf("%s: Want: %v, Got: %v", tt.accept, tt.contentType, got)
+		}
+		if tt.want == "" {
+			continue
+		}
+
+		if got := strings.TrimSpace(rec.Body.String()); got != tt.want {
+			t.Fatalf("%s: Want: %v, Got: %v", tt.accept, tt.want, got)
+		}
+	}
+}
+
+func TestGetAllTodoPagination(t *testing.T) {
+	repository := newRepository(t)
+	testServer := setupServer(repository)
+
+	for _, title := range []string{"My Task1", "My Task2", "My Task3"} {
+		todo := &schema.Todo{
+			Title:   title,
+			DueDate: time.Date(2000, 1, 1, 0, 0, 0, 0, time.Local),
+		}
+		if _, err := repository.Insert(context.Background(), todo); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	url := "http://localhost:8080/todo?limit=2&sort=-id"
+	var gotIDs []int
+	for url != "" {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rec := httptest.NewRecorder()
+		testServer.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Want: %v, Got: %v", http.StatusOK, rec.Code)
+		}
+
+		if got := rec.Header().Get("X-Total-Count"); got != "3" {
+			t.Fatalf("Want: %v, Got: %v", "3", got)
+		}
+
+		var page []schema.Todo
+		if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+			t.Fatal(err)
+		}
+		for _, todo := range page {
+			gotIDs = append(gotIDs, todo.ID)
+		}
+
+		url = ""
+		if cursor := rec.Header().Get("X-Next-Cursor"); cursor != "" {
+			url = "http://localhost:8080/todo?limit=2&sort=-id&cursor=" + cursor
+		}
+	}
+
+	if want := []int{3, 2, 1}; !reflect.DeepEqual(gotIDs, want) {
+		t.Fatalf("Want: %v, Got: %v", want, gotIDs)
+	}
+}
+
+func TestGetAllTodoInvalidQuery(t *testing.T) {
+	testServer := setupServer(nil)
+
+	for _, query := range []string{"limit=0", "sort=note", "due_before=yesterday", "cursor=x&offset=1"} {
+		req, err := http.NewRequest(http.MethodGet, "http://localhost:8080/todo?"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rec := httptest.NewRecorder()
+		testServer.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("%s: Want: %v, Got: %v", query, http.StatusBadRequest, rec.Code)
+		}
+	}
+}
+
+func TestGetTodo(t *testing.T) {
+	repository := newRepository(t)
+	testServer := setupServer(repository)
+
+	todo := &schema.Todo{
+		Title:   "My Task1",
+		DueDate: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	id, err := repository.Insert(context.Background(), todo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://localhost:8080/todo/%d", id), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	testServer.ServeHTTP(rec, req)
+
+	var got schema.Todo
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.ID != id || got.Title != "My Task1" || !got.DueDate.Equal(todo.DueDate) {
+		t.Fatalf("Want: %v, Got: %v", todo, got)
+	}
+}
+
+func TestGetTodoNotFound(t *testing.T) {
+	repository := newRepository(t)
+	testServer := setupServer(repository)
+
+	req, err := http.NewRequest(http.MethodGet, "http://localhost:8080/todo/1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Request-ID", "test")
+
+	rec := httptest.NewRecorder()
+	testServer.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("Want: %v, Got: %v", http.StatusNotFound, rec.Code)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/problem+json" {
+		t.Fatalf("Want: %v, Got: %v", "application/problem+json", got)
+	}
+
+	got := strings.TrimSpace(rec.Body.String())
+	want := `{"type":"about:blank","title":"Not Found","status":404,"detail":"todo not found","code":"not_found","request_id":"test"}`
 
 	if got != want {
 		t.Fatalf("Want: %v, Got: %v", want, got)
@@ -68,10 +267,10 @@ func TestGetAllTodo(t *testing.T) {
 }
 
 func TestSaveTodo(t *testing.T) {
-	postgres := &db.Postgres{testdb.Setup()}
-	testServer := setupServer(postgres)
+	repository := newRepository(t)
+	testServer := setupServer(repository)
 
-	body := []byte(`{"id":1,"title":"My Task1","note":"","due_date":"2000-01-01T00:00:00+09:00"}`)
+	body := []byte(`{"title":"My Task1","note":"","due_date":"2000-01-01T00:00:00+09:00"}`)
 
 	req, err := http.NewRequest(http.MethodPost, "http://localhost:8080/todo", bytes.NewReader(body))
 	if err != nil {
@@ -81,40 +280,137 @@ func TestSaveTodo(t *testing.T) {
 	rec := httptest.NewRecorder()
 	testServer.ServeHTTP(rec, req)
 
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Want: %v, Got: %v", http.StatusCreated, rec.Code)
+	}
+
+	if got, want := rec.Header().Get("Location"), "/todo/1"; got != want {
+		t.Fatalf("Want: %v, Got: %v", want, got)
+	}
+
+	gotTodo, err := repository.Get(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if gotTodo.Title != "My Task1" {
+		t.Fatalf("Want: %v, Got: %v", "My Task1", gotTodo.Title)
+	}
+
+	updatedAt := gotTodo.UpdatedAt.UTC().Format(time.RFC3339Nano)
 	got := strings.TrimSpace(rec.Body.String())
-	want := "1"
+	want := `{"id":1,"title":"My Task1","note":"","due_date":"2000-01-01T00:00:00+09:00","status":"open","created_at":"` + updatedAt + `","updated_at":"` + updatedAt + `","version":1}`
 
 	if got != want {
 		t.Fatalf("Want: %v, Got: %v", want, got)
 	}
+}
+
+func TestSaveTodoValidation(t *testing.T) {
+	repository := newRepository(t)
+	testServer := setupServer(repository)
 
-	gotTodo, err := postgres.GetAll()
+	id, err := repository.Insert(context.Background(), &schema.Todo{Title: "My Task1"})
 	if err != nil {
 		t.Fatal(err)
 	}
+	url := fmt.Sprintf("http://localhost:8080/todo/%d", id)
 
-	wantTodo := []schema.Todo{
+	tests := []struct {
+		method string
+		url    string
+		body   string
+		code   int
+		want   string
+	}{
 		{
-			Title:   "My Task1",
-			DueDate: time.Date(2000, 1, 1, 0, 0, 0, 0, time.Local),
+			http.MethodPost, "http://localhost:8080/todo", `{"title":"  ","note":"` + strings.Repeat("a", schema.MaxNoteLength+1) + `"}`,
+			http.StatusUnprocessableEntity,
+			`{"type":"about:blank","title":"Unprocessable Entity","status":422,"detail":"the todo is invalid","code":"validation_failed","request_id":"test","errors":[{"field":"title","code":"required","message":"title is required"},{"field":"note","code":"too_long","message":"note must be at most 10000 characters"}]}`,
 		},
+		{
+			http.MethodPost, "http://localhost:8080/todo", `{"id":5,"title":"My Task2","due_date":"1800-01-01T00:00:00Z","status":"later"}`,
+			http.StatusUnprocessableEntity,
+			`{"type":"about:blank","title":"Unprocessable Entity","status":422,"detail":"the todo is invalid","code":"validation_failed","request_id":"test","errors":[{"field":"id","code":"read_only","message":"id is assigned by the server"},{"field":"due_date","code":"out_of_range","message":"due_date must be between 1900 and 9999"},{"field":"status","code":"invalid","message":"status must be one of open, in_progress, done or archived"}]}`,
+		},
+		{
+			http.MethodPost, "http://localhost:8080/todo", `{"title":"My Task2","version":3,"created_at":"2000-01-01T00:00:00Z","updated_at":"2000-01-01T00:00:00Z","completed_at":"2000-01-01T00:00:00Z"}`,
+			http.StatusUnprocessableEntity,
+			`{"type":"about:blank","title":"Unprocessable Entity","status":422,"detail":"the todo is invalid","code":"validation_failed","request_id":"test","errors":[{"field":"version","code":"read_only","message":"version is assigned by the server"},{"field":"created_at","code":"read_only","message":"created_at is assigned by the server"},{"field":"updated_at","code":"read_only","message":"updated_at is assigned by the server"},{"field":"completed_at","code":"read_only","message":"completed_at is assigned by the server"}]}`,
+		},
+		{
+			http.MethodPost, "http://localhost:8080/todo", `{"title":"My Task2","priority":1}`,
+			http.StatusUnprocessableEntity,
+			`{"type":"about:blank","title":"Unprocessable Entity","status":422,"detail":"the todo is invalid","code":"validation_failed","request_id":"test","errors":[{"field":"priority","code":"unknown_field","message":"unknown field \"priority\""}]}`,
+		},
+		{
+			http.MethodPost, "http://localhost:8080/todo", `{"Title":"My Task2","tags":[],"done":true}`,
+			http.StatusUnprocessableEntity,
+			`{"type":"about:blank","title":"Unprocessable Entity","status":422,"detail":"the todo is invalid","code":"validation_failed","request_id":"test","errors":[{"field":"done","code":"unknown_field","message":"unknown field \"done\""},{"field":"tags","code":"unknown_field","message":"unknown field \"tags\""}]}`,
+		},
+		{
+			http.MethodPost, "http://localhost:8080/todo", `{"title":"My Task2"}{}`,
+			http.StatusBadRequest,
+			`{"type":"about:blank","title":"Bad Request","status":400,"detail":"invalid character after top-level value","code":"invalid_body","request_id":"test"}`,
+		},
+		{
+			http.MethodPost, "http://localhost:8080/todo", `{"title":"My Task2","note":"` + strings.Repeat("a", 1<<20) + `"}`,
+			http.StatusRequestEntityTooLarge,
+			`{"type":"about:blank","title":"Request Entity Too Large","status":413,"detail":"the body must be at most 1048576 bytes","code":"body_too_large","request_id":"test"}`,
+		},
+		{
+			http.MethodPut, url, `{"id":2,"title":"My Task2"}`,
+			http.StatusUnprocessableEntity,
+			`{"type":"about:blank","title":"Unprocessable Entity","status":422,"detail":"the todo is invalid","code":"validation_failed","request_id":"test","errors":[{"field":"id","code":"read_only","message":"id must match the URL"}]}`,
+		},
+		{
+			http.MethodPatch, url, `{"title":"` + strings.Repeat("a", schema.MaxTitleLength+1) + `"}`,
+			http.StatusUnprocessableEntity,
+			`{"type":"about:blank","title":"Unprocessable Entity","status":422,"detail":"the todo is invalid","code":"validation_failed","request_id":"test","errors":[{"field":"title","code":"too_long","message":"title must be at most 200 characters"}]}`,
+		},
+		{
+			http.MethodPatch, url, `{"done":true}`,
+			http.StatusUnprocessableEntity,
+			`{"type":"about:blank","title":"Unprocessable Entity","status":422,"detail":"the todo is invalid","code":"validation_failed","request_id":"test","errors":[{"field":"done","code":"unknown_field","message":"unknown field \"done\""}]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		req, err := http.NewRequest(tt.method, tt.url, strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Request-ID", "test")
+		req.Header.Set("If-Match", "*")
+
+		rec := httptest.NewRecorder()
+		testServer.ServeHTTP(rec, req)
+
+		got := strings.TrimSpace(rec.Body.String())
+		if rec.Code != tt.code || got != tt.want {
+			t.Fatalf("%s %.40s: Want: %d %v, Got: %d %v", tt.method, tt.body, tt.code, tt.want, rec.Code, got)
+		}
 	}
 
-	if !reflect.DeepEqual(got, want) {
-		t.Fatalf("Want: %v, Got: %v\n", wantTodo, gotTodo)
+	todoList, err := repository.GetAll(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(todoList) != 1 || todoList[0].Title != "My Task1" {
+		t.Fatalf("Want: [My Task1], Got: %v", todoList)
 	}
 }
 
 func TestDeleteTodo(t *testing.T) {
-	postgres := &db.Postgres{testdb.Setup()}
-	testServer := setupServer(postgres)
+	repository := newRepository(t)
+	testServer := setupServer(repository)
 
 	todo := &schema.Todo{
 		Title:   "My Task1",
 		DueDate: time.Date(2000, 1, 1, 0, 0, 0, 0, time.Local),
 	}
 
-	id, err := postgres.Insert(todo)
+	id, err := repository.Insert(context.Background(), todo)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -126,9 +422,28 @@ func TestDeleteTodo(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	req.Header.Set("If-Match", `"99"`)
+
 	rec := httptest.NewRecorder()
 	testServer.ServeHTTP(rec, req)
 
+	if rec.Code != http.StatusPreconditionFailed {
+		t.Fatalf("Want: %v, Got: %v", http.StatusPreconditionFailed, rec.Code)
+	}
+
+	// Clients written before ETags existed send no If-Match.
+	req, err = http.NewRequest(http.MethodDelete, "http://localhost:9999/todo", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec = httptest.NewRecorder()
+	testServer.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("Want: %v, Got: %v", http.StatusNoContent, rec.Code)
+	}
+
 	got := rec.Body.String()
 
 	want := ""
@@ -137,7 +452,44 @@ func TestDeleteTodo(t *testing.T) {
 		t.Fatalf("Want: %v, Got: %v", want, got)
 	}
 
-	gotTodo, err := postgres.GetAll()
+	gotTodo, err := repository.GetAll(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(gotTodo) > 0 {
+		t.Fatalf("Should return the empty slice, Got: %v\n", gotTodo)
+	}
+}
+
+func TestDeleteTodoByID(t *testing.T) {
+	repository := newRepository(t)
+	testServer := setupServer(repository)
+
+	todo := &schema.Todo{
+		Title:   "My Task1",
+		DueDate: time.Date(2000, 1, 1, 0, 0, 0, 0, time.Local),
+	}
+
+	id, err := repository.Insert(context.Background(), todo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("http://localhost:8080/todo/%d", id), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("If-Match", "*")
+
+	rec := httptest.NewRecorder()
+	testServer.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("Want: %v, Got: %v", http.StatusNoContent, rec.Code)
+	}
+
+	gotTodo, err := repository.GetAll(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -147,6 +499,534 @@ func TestDeleteTodo(t *testing.T) {
 	}
 }
 
-func setupServer(postgres *db.Postgres) *http.ServeMux {
-	return handler.SetUpRouting(postgres)
+func TestMethodNotAllowed(t *testing.T) {
+	testServer := handler.SetUpRouting(service.NewTodoService(&db.Sample{}), handler.Options{})
+
+	tests := []struct {
+		method string
+		url    string
+		allow  string
+	}{
+		{http.MethodDelete, "http://localhost:8080/todo", "GET, POST"},
+		{http.MethodPost, "http://localhost:8080/todo/1", "GET, PUT, PATCH, DELETE"},
+		{http.MethodPut, "http://localhost:8080/samples", "GET"},
+	}
+
+	for _, tt := range tests {
+		req, err := http.NewRequest(tt.method, tt.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rec := httptest.NewRecorder()
+		testServer.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusMethodNotAllowed {
+			t.Fatalf("%s %s: Want: %v, Got: %v", tt.method, tt.url, http.StatusMethodNotAllowed, rec.Code)
+		}
+
+		if got := rec.Header().Get("Allow"); got != tt.allow {
+			t.Fatalf("%s %s: Want: %v, Got: %v", tt.method, tt.url, tt.allow, got)
+		}
+	}
+}
+
+func TestUpdateTodo(t *testing.T) {
+	repository := newRepository(t)
+	testServer := setupServer(repository)
+
+	todo := &schema.Todo{
+		Title:   "My Task1",
+		Note:    "note1",
+		DueDate: time.Date(2000, 1, 1, 0, 0, 0, 0, time.Local),
+	}
+
+	id, err := repository.Insert(context.Background(), todo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := []byte(`{"title":"My Task2","due_date":"2001-01-01T00:00:00Z"}`)
+
+	req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("http://localhost:8080/todo/%d", id), bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("If-Match", "*")
+
+	rec := httptest.NewRecorder()
+	testServer.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Want: %v, Got: %v", http.StatusOK, rec.Code)
+	}
+
+	gotTodo, err := repository.GetAll(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(gotTodo) != 1 || gotTodo[0].Title != "My Task2" || gotTodo[0].Note != "" {
+		t.Fatalf("The record is not replaced, Got: %v\n", gotTodo)
+	}
+}
+
+func TestPatchTodo(t *testing.T) {
+	repository := newRepository(t)
+	testServer := setupServer(repository)
+
+	todo := &schema.Todo{
+		Title:   "My Task1",
+		Note:    "note1",
+		DueDate: time.Date(2000, 1, 1, 0, 0, 0, 0, time.Local),
+	}
+
+	id, err := repository.Insert(context.Background(), todo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := []byte(`{"title":"My Task2"}`)
+
+	req, err := http.NewRequest(http.MethodPatch, fmt.Sprintf("http://localhost:8080/todo/%d", id), bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("If-Match", "*")
+
+	rec := httptest.NewRecorder()
+	testServer.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Want: %v, Got: %v", http.StatusOK, rec.Code)
+	}
+
+	gotTodo, err := repository.GetAll(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(gotTodo) != 1 || gotTodo[0].Title != "My Task2" || gotTodo[0].Note != "note1" {
+		t.Fatalf("The record is not patched, Got: %v\n", gotTodo)
+	}
+}
+
+func TestUpdateTodoNotFound(t *testing.T) {
+	repository := newRepository(t)
+	testServer := setupServer(repository)
+
+	body := []byte(`{"title":"My Task1"}`)
+
+	for _, method := range []string{http.MethodPut, http.MethodPatch} {
+		req, err := http.NewRequest(method, "http://localhost:8080/todo/1", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("If-Match", "*")
+
+		rec := httptest.NewRecorder()
+		testServer.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusNotFound {
+			t.Fatalf("%s: Want: %v, Got: %v", method, http.StatusNotFound, rec.Code)
+		}
+	}
+}
+
+func TestConditionalRequests(t *testing.T) {
+	repository := newRepository(t)
+	testServer := setupServer(repository)
+
+	id, err := repository.Insert(context.Background(), &schema.Todo{Title: "My Task1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := fmt.Sprintf("http://localhost:8080/todo/%d", id)
+
+	serve := func(method string, header string, value string, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, url, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+
+		rec := httptest.NewRecorder()
+		testServer.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve(http.MethodGet, "", "", "")
+	etag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || etag == "" || rec.Header().Get("Last-Modified") == "" {
+		t.Fatalf("Want: 200 with ETag and Last-Modified, Got: %v %v", rec.Code, rec.Header())
+	}
+
+	rec = serve(http.MethodGet, "If-None-Match", etag, "")
+	if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Fatalf("Want: %v, Got: %v %v", http.StatusNotModified, rec.Code, rec.Body)
+	}
+
+	rec = serve(http.MethodGet, "If-Modified-Since", rec.Header().Get("Last-Modified"), "")
+	if rec.Code != http.StatusNotModified {
+		t.Fatalf("Want: %v, Got: %v", http.StatusNotModified, rec.Code)
+	}
+
+	rec = serve(http.MethodPut, "", "", `{"title":"My Task2"}`)
+	if rec.Code != http.StatusPreconditionRequired {
+		t.Fatalf("Want: %v, Got: %v", http.StatusPreconditionRequired, rec.Code)
+	}
+
+	rec = serve(http.MethodPut, "If-Match", etag, `{"title":"My Task2"}`)
+	updated := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || updated == "" || updated == etag {
+		t.Fatalf("Want: 200 with a new ETag, Got: %v %v", rec.Code, updated)
+	}
+
+	// The old tag no longer matches, so a client that missed the update
+	// cannot overwrite it, and is sent the current todo instead.
+	for _, method := range []string{http.MethodPut, http.MethodPatch, http.MethodDelete} {
+		rec = serve(method, "If-Match", etag, `{"title":"My Task3"}`)
+		if rec.Code != http.StatusPreconditionFailed {
+			t.Fatalf("%s: Want: %v, Got: %v", method, http.StatusPreconditionFailed, rec.Code)
+		}
+		if got := rec.Header().Get("ETag"); got != updated {
+			t.Fatalf("%s: Want: %v, Got: %v", method, updated, got)
+		}
+
+		var got struct {
+			Code    string       `json:"code"`
+			Current *schema.Todo `json:"current"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Code != "precondition_failed" || got.Current == nil || got.Current.Title != "My Task2" {
+			t.Fatalf("%s: Want: precondition_failed with My Task2, Got: %v", method, rec.Body)
+		}
+	}
+
+	rec = serve(http.MethodGet, "If-None-Match", etag, "")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "My Task2") {
+		t.Fatalf("Want: %v, Got: %v %v", http.StatusOK, rec.Code, rec.Body)
+	}
+
+	rec = serve(http.MethodDelete, "If-Match", updated, "")
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("Want: %v, Got: %v", http.StatusNoContent, rec.Code)
+	}
+}
+
+func TestUpdateTodoConflict(t *testing.T) {
+	repository := newRepository(t)
+	testServer := setupServer(repository)
+
+	id, err := repository.Insert(context.Background(), &schema.Todo{Title: "My Task1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := fmt.Sprintf("http://localhost:8080/todo/%d", id)
+
+	// Both clients read version 1, and the first one saves its change.
+	if err := repository.Update(context.Background(), &schema.Todo{ID: id, Title: "My Task2", Version: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, method := range []string{http.MethodPut, http.MethodPatch} {
+		req, err := http.NewRequest(method, url, strings.NewReader(`{"title":"My Task3","version":1}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("If-Match", "*")
+
+		rec := httptest.NewRecorder()
+		testServer.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusConflict {
+			t.Fatalf("%s: Want: %v, Got: %v", method, http.StatusConflict, rec.Code)
+		}
+		if got := rec.Header().Get("ETag"); got != `"2"` {
+			t.Fatalf("%s: Want: %v, Got: %v", method, `"2"`, got)
+		}
+
+		var got struct {
+			Code    string       `json:"code"`
+			Current *schema.Todo `json:"current"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Code != "version_conflict" || got.Current == nil || got.Current.Title != "My Task2" || got.Current.Version != 2 {
+			t.Fatalf("%s: Want: version_conflict with My Task2, Got: %v", method, rec.Body)
+		}
+	}
+
+	todo, err := repository.Get(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if todo.Title != "My Task2" {
+		t.Fatalf("Want: %v, Got: %v", "My Task2", todo.Title)
+	}
+}
+
+func TestCompleteAndReopenTodo(t *testing.T) {
+	repository := newRepository(t)
+	testServer := setupServer(repository)
+
+	todo := &schema.Todo{
+		Title:   "My Task1",
+		DueDate: time.Date(2000, 1, 1, 0, 0, 0, 0, time.Local),
+	}
+
+	id, err := repository.Insert(context.Background(), todo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://localhost:8080/todo/%d/complete", id), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	testServer.ServeHTTP(rec, req)
+
+	var got schema.Todo
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Status != schema.StatusDone || got.CompletedAt == nil {
+		t.Fatalf("The todo is not completed, Got: %v", got)
+	}
+
+	// The response holds the todo as it reads back.
+	stored, err := repository.Get(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stored.CompletedAt.Equal(*got.CompletedAt) || got.CompletedAt.Location() != time.UTC {
+		t.Fatalf("Want: %v, Got: %v", stored.CompletedAt, got.CompletedAt)
+	}
+
+	// Completing it again changes nothing.
+	req, err = http.NewRequest(http.MethodPost, fmt.Sprintf("http://localhost:8080/todo/%d/complete", id), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec = httptest.NewRecorder()
+	testServer.ServeHTTP(rec, req)
+
+	var again schema.Todo
+	if err := json.Unmarshal(rec.Body.Bytes(), &again); err != nil {
+		t.Fatal(err)
+	}
+	if again.Version != got.Version || !again.UpdatedAt.Equal(got.UpdatedAt) {
+		t.Fatalf("Want: version %d, Got: version %d", got.Version, again.Version)
+	}
+
+	req, err = http.NewRequest(http.MethodPost, fmt.Sprintf("http://localhost:8080/todo/%d/reopen", id), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec = httptest.NewRecorder()
+	testServer.ServeHTTP(rec, req)
+
+	gotTodo, err := repository.Get(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if gotTodo.Status != schema.StatusOpen || gotTodo.CompletedAt != nil {
+		t.Fatalf("The todo is not reopened, Got: %v", gotTodo)
+	}
+}
+
+func TestInvalidStatusTransition(t *testing.T) {
+	repository := newRepository(t)
+	testServer := setupServer(repository)
+
+	todo := &schema.Todo{
+		Title:  "My Task1",
+		Status: schema.StatusArchived,
+	}
+
+	id, err := repository.Insert(context.Background(), todo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://localhost:8080/todo/%d/complete", id), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	testServer.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("Want: %v, Got: %v", http.StatusConflict, rec.Code)
+	}
+}
+
+func TestTodoBatch(t *testing.T) {
+	repository := newRepository(t)
+	testServer := setupServer(repository)
+
+	tests := []struct {
+		method  string
+		ifMatch string
+		body    string
+		code    int
+		want    string
+		titles  []string
+	}{
+		{
+			http.MethodPost, "",
+			`[{"title":"My Task1"},{"title":"My Task2","status":"done"},{"title":"My Task3"}]`,
+			http.StatusOK,
+			`[{"id":1,"status":201},{"id":2,"status":201},{"id":3,"status":201}]`,
+			[]string{"My Task1", "My Task2", "My Task3"},
+		},
+		{
+			http.MethodPost, "",
+			`[{"title":"My Task4"},{"title":""}]`,
+			http.StatusUnprocessableEntity,
+			`[{"status":424,"code":"batch_failed","error":"not stored because other todos are invalid"},{"status":422,"code":"validation_failed","error":"the todo is invalid","errors":[{"field":"title","code":"required","message":"title is required"}]}]`,
+			[]string{"My Task1", "My Task2", "My Task3"},
+		},
+		{
+			http.MethodPost, "",
+			`[{"title":"My Task4"},{"title":"My Task5","prio":1},{"title":5}]`,
+			http.StatusUnprocessableEntity,
+			`[{"status":424,"code":"batch_failed","error":"not stored because other todos are invalid"},{"status":422,"code":"validation_failed","error":"the todo is invalid","errors":[{"field":"prio","code":"unknown_field","message":"unknown field \"prio\""}]},{"status":400,"code":"invalid_body","error":"json: cannot unmarshal number into Go struct field Todo.title of type string"}]`,
+			[]string{"My Task1", "My Task2", "My Task3"},
+		},
+		{
+			http.MethodPost, "",
+			`[]`,
+			http.StatusBadRequest,
+			`{"type":"about:blank","title":"Bad Request","status":400,"detail":"the batch is empty","code":"invalid_batch","request_id":"test"}`,
+			[]string{"My Task1", "My Task2", "My Task3"},
+		},
+		{
+			http.MethodDelete, "",
+			`{"ids":[3,99,1]}`,
+			http.StatusPreconditionRequired,
+			`{"type":"about:blank","title":"Precondition Required","status":428,"detail":"the If-Match header is required","code":"precondition_required","request_id":"test"}`,
+			[]string{"My Task1", "My Task2", "My Task3"},
+		},
+		{
+			http.MethodDelete, `"1"`,
+			`{"ids":[3,99,1]}`,
+			http.StatusPreconditionFailed,
+			`{"type":"about:blank","title":"Precondition Failed","status":412,"detail":"only \"If-Match: *\" applies to a batch","code":"precondition_failed","request_id":"test"}`,
+			[]string{"My Task1", "My Task2", "My Task3"},
+		},
+		{
+			http.MethodDelete, "*",
+			`{"ids":[3,99,1]}`,
+			http.StatusOK,
+			`[{"id":3,"status":200},{"id":99,"status":404,"code":"not_found","error":"todo not found"},{"id":1,"status":200}]`,
+			[]string{"My Task2"},
+		},
+	}
+
+	for _, tt := range tests {
+		req, err := http.NewRequest(tt.method, "http://localhost:8080/todo/batch", strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Request-ID", "test")
+		if tt.ifMatch != "" {
+			req.Header.Set("If-Match", tt.ifMatch)
+		}
+
+		rec := httptest.NewRecorder()
+		testServer.ServeHTTP(rec, req)
+
+		got := strings.TrimSpace(rec.Body.String())
+		if rec.Code != tt.code || got != tt.want {
+			t.Fatalf("%s %s: Want: %d %v, Got: %d %v", tt.method, tt.body, tt.code, tt.want, rec.Code, got)
+		}
+
+		todoList, err := repository.GetAll(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		titles := []string{}
+		for _, todo := range todoList {
+			titles = append(titles, todo.Title)
+		}
+		if !reflect.DeepEqual(titles, tt.titles) {
+			t.Fatalf("%s %s: Want: %v, Got: %v", tt.method, tt.body, tt.titles, titles)
+		}
+	}
+}
+
+// failingRepository fails every read like a broken database would.
+type failingRepository struct {
+	*db.Memory
+}
+
+func (r failingRepository) Get(ctx context.Context, id int) (*schema.Todo, error) {
+	return nil, errors.New(`pq: relation "todo" does not exist`)
+}
+
+func TestInternalError(t *testing.T) {
+	testServer := setupServer(failingRepository{db.NewMemory()})
+
+	req, err := http.NewRequest(http.MethodGet, "http://localhost:8080/todo/1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	testServer.ServeHTTP(rec, req)
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+
+	id := rec.Header().Get("X-Request-ID")
+	if rec.Code != http.StatusInternalServerError || got["code"] != "internal" || got["detail"] != "internal error" {
+		t.Fatalf("Want: %v internal, Got: %v %v", http.StatusInternalServerError, rec.Code, got)
+	}
+	if id == "" || got["request_id"] != id {
+		t.Fatalf("Want: %v, Got: %v", id, got["request_id"])
+	}
+}
+
+// newRepository returns the repository the functional tests run against: an
+// in-memory one by default, or the one TODO_TEST_BACKEND names, postgres,
+// sqlite or file.
+func newRepository(t *testing.T) db.Repository {
+	switch os.Getenv("TODO_TEST_BACKEND") {
+	case "postgres":
+		return testdb.SetupTx(t)
+	case "sqlite":
+		sqlite, err := db.ConnectSQLite(filepath.Join(t.TempDir(), "todo.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sqlite
+	case "file":
+		store, err := db.OpenFileStore(filepath.Join(t.TempDir(), "todo.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	}
+
+	return db.NewMemory()
+}
+
+func setupServer(repository db.Repository) http.Handler {
+	return handler.SetUpRouting(service.NewTodoService(repository), handler.Options{LegacyDeleteBody: true})
 }
@@ -0,0 +1,164 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/cohhei/go-to-the-handson/04/db"
+	"github.com/cohhei/go-to-the-handson/04/schema"
+	"github.com/cohhei/go-to-the-handson/04/service"
+)
+
+const (
+	maxBatchSize     = 1000
+	maxBatchBodySize = 10 << 20
+)
+
+// batchResult is the outcome of one item of a batch request. Status and
+// Code are those the item would have had as a request of its own.
+type batchResult struct {
+	ID     int                    `json:"id,omitempty"`
+	Status int                    `json:"status"`
+	Code   string                 `json:"code,omitempty"`
+	Error  string                 `json:"error,omitempty"`
+	Errors schema.ValidationError `json:"errors,omitempty"`
+}
+
+// saveTodoBatch stores an array of todos in one transaction. If any todo is
+// invalid nothing is stored, and the results tell which todos are invalid.
+func (handler *todoHandler) saveTodoBatch(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	b, ok := readBody(w, r, maxBatchBodySize)
+	if !ok {
+		return
+	}
+
+	// Items are decoded one by one, so that the results tell which todos
+	// could not be decoded.
+	var items []json.RawMessage
+	if err := decodeJSON(b, &items); err != nil {
+		responseDecodeError(w, err)
+		return
+	}
+	if err := checkBatchSize(len(items)); err != nil {
+		responseError(w, http.StatusBadRequest, "invalid_batch", err.Error())
+		return
+	}
+
+	todos := make([]schema.Todo, len(items))
+	decodeErr := service.BatchError{}
+	for i, item := range items {
+		if err := decodeJSON(item, &todos[i]); err != nil {
+			decodeErr[i] = err
+		}
+	}
+	if len(decodeErr) > 0 {
+		responseJSON(w, http.StatusUnprocessableEntity, batchErrorResults(len(todos), decodeErr))
+		return
+	}
+
+	ids, err := handler.todos.InsertMany(ctx, todos)
+	var batchErr service.BatchError
+	if errors.As(err, &batchErr) {
+		responseJSON(w, http.StatusUnprocessableEntity, batchErrorResults(len(todos), batchErr))
+		return
+	}
+	if err != nil {
+		responseServiceError(w, r, err)
+		return
+	}
+
+	results := make([]batchResult, len(ids))
+	for i, id := range ids {
+		results[i] = batchResult{ID: id, Status: http.StatusCreated}
+	}
+	responseOk(w, results)
+}
+
+// deleteTodoBatch deletes the todos with the IDs in the body in one
+// transaction. IDs without a todo are reported but do not fail the batch.
+func (handler *todoHandler) deleteTodoBatch(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if !requireIfMatchAny(w, r) {
+		return
+	}
+
+	b, ok := readBody(w, r, maxBatchBodySize)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		IDs []int `json:"ids"`
+	}
+	if err := decodeJSON(b, &req); err != nil {
+		responseDecodeError(w, err)
+		return
+	}
+	if err := checkBatchSize(len(req.IDs)); err != nil {
+		responseError(w, http.StatusBadRequest, "invalid_batch", err.Error())
+		return
+	}
+
+	deleted, err := handler.todos.DeleteMany(ctx, req.IDs)
+	if err != nil {
+		responseServiceError(w, r, err)
+		return
+	}
+
+	found := map[int]bool{}
+	for _, id := range deleted {
+		found[id] = true
+	}
+
+	results := make([]batchResult, len(req.IDs))
+	for i, id := range req.IDs {
+		if found[id] {
+			results[i] = batchResult{ID: id, Status: http.StatusOK}
+		} else {
+			results[i] = batchResult{ID: id, Status: http.StatusNotFound, Code: "not_found", Error: db.ErrNotFound.Error()}
+		}
+	}
+	responseOk(w, results)
+}
+
+// batchErrorResults returns the results of a batch of n items that failed
+// because of errs. The items without an error were not stored either.
+func batchErrorResults(n int, errs service.BatchError) []batchResult {
+	results := make([]batchResult, n)
+	for i := range results {
+		err, ok := errs[i]
+		if !ok {
+			results[i] = batchResult{Status: http.StatusFailedDependency, Code: "batch_failed", Error: "not stored because other todos are invalid"}
+			continue
+		}
+
+		var e *service.Error
+		var validationErr schema.ValidationError
+		switch {
+		case errors.As(err, &e):
+			results[i] = batchResult{Status: statusOf(e.Kind), Code: e.Code, Error: e.Message, Errors: e.Fields}
+		case errors.As(err, &validationErr):
+			results[i] = batchResult{Status: http.StatusUnprocessableEntity, Code: "validation_failed", Error: "the todo is invalid", Errors: validationErr}
+		default:
+			results[i] = batchResult{Status: http.StatusBadRequest, Code: "invalid_body", Error: err.Error()}
+		}
+	}
+
+	return results
+}
+
+func checkBatchSize(n int) error {
+	if n == 0 {
+		return errors.New("the batch is empty")
+	}
+	if n > maxBatchSize {
+		return fmt.Errorf("the batch has %d items, the maximum is %d", n, maxBatchSize)
+	}
+
+	return nil
+}
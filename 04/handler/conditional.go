@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cohhei/go-to-the-handson/04/schema"
+	"github.com/cohhei/go-to-the-handson/04/service"
+)
+
+// etag returns the entity tag of todo, its version.
+func etag(todo *schema.Todo) string {
+	return `"` + strconv.Itoa(todo.Version) + `"`
+}
+
+// setValidators sets the ETag and Last-Modified headers of todo.
+func setValidators(w http.ResponseWriter, todo *schema.Todo) {
+	w.Header().Set("ETag", etag(todo))
+	w.Header().Set("Last-Modified", todo.UpdatedAt.UTC().Format(http.TimeFormat))
+}
+
+// notModified reports whether the client already has todo, as told by the
+// If-None-Match header of r or, without one, by If-Modified-Since.
+func notModified(r *http.Request, todo *schema.Todo) bool {
+	if header := headerList(r, "If-None-Match"); header != "" {
+		return matchETag(header, etag(todo), true)
+	}
+
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+
+	return !todo.UpdatedAt.Truncate(time.Second).After(since)
+}
+
+// ifMatch returns the precondition of the If-Match header of r, or nil if r
+// has none.
+func ifMatch(r *http.Request) service.Precondition {
+	header := headerList(r, "If-Match")
+	if header == "" {
+		return nil
+	}
+
+	return func(current *schema.Todo) bool {
+		return matchETag(header, etag(current), false)
+	}
+}
+
+// requireIfMatch returns the precondition of the If-Match header of r, or
+// answers with 428 if r has none, so that clients cannot overwrite changes
+// they have not seen.
+func requireIfMatch(w http.ResponseWriter, r *http.Request) (service.Precondition, bool) {
+	pre := ifMatch(r)
+	if pre == nil {
+		responseError(w, http.StatusPreconditionRequired, "precondition_required", "the If-Match header is required")
+		return nil, false
+	}
+
+	return pre, true
+}
+
+// requireIfMatchAny answers with 428 unless r has the If-Match header "*",
+// and with 412 if it has another one. Requests changing several todos have
+// no single entity tag to match, so clients must state that they mean to
+// change the todos whatever their versions are.
+func requireIfMatchAny(w http.ResponseWriter, r *http.Request) bool {
+	switch strings.TrimSpace(headerList(r, "If-Match")) {
+	case "*":
+		return true
+	case "":
+		responseError(w, http.StatusPreconditionRequired, "precondition_required", "the If-Match header is required")
+	default:
+		responseError(w, http.StatusPreconditionFailed, "precondition_failed", `only "If-Match: *" applies to a batch`)
+	}
+	return false
+}
+
+// matchETag reports whether header, "*" or a list of entity tags, holds tag.
+// Weak tags only match when weak is true, since If-Match compares strongly.
+func matchETag(header string, tag string, weak bool) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" {
+			return true
+		}
+		if strings.HasPrefix(t, "W/") {
+			if !weak {
+				continue
+			}
+			t = t[len("W/"):]
+		}
+		if t == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+func headerList(r *http.Request, name string) string {
+	return strings.Join(r.Header.Values(name), ",")
+}
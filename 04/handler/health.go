@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+)
+
+// Readiness serves /healthz and /readyz in front of the API so that the
+// server can listen before its dependencies are available. /healthz always
+// succeeds; /readyz and every other route answer 503 until SetReady installs
+// the API handler.
+type Readiness struct {
+	mu    sync.RWMutex
+	next  http.Handler
+	check func(ctx context.Context) error
+}
+
+func NewReadiness() *Readiness {
+	return &Readiness{}
+}
+
+// SetReady starts forwarding requests to next. check, if not nil, is called by
+// /readyz to confirm that the dependencies are still reachable.
+func (rd *Readiness) SetReady(next http.Handler, check func(ctx context.Context) error) {
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+
+	rd.next = next
+	rd.check = check
+}
+
+func (rd *Readiness) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rd.mu.RLock()
+	next, check := rd.next, rd.check
+	rd.mu.RUnlock()
+
+	switch r.URL.Path {
+	case "/healthz":
+		responseOk(w, map[string]string{"status": "ok"})
+		return
+	case "/readyz":
+		if next == nil {
+			responseError(w, http.StatusServiceUnavailable, "not_ready", "the service is starting")
+			return
+		}
+		if check != nil {
+			if err := check(r.Context()); err != nil {
+				slog.Warn("readiness check failed", "error", err)
+				responseError(w, http.StatusServiceUnavailable, "unavailable", "the database is unavailable")
+				return
+			}
+		}
+		responseOk(w, map[string]string{"status": "ready"})
+		return
+	}
+
+	if next == nil {
+		responseError(w, http.StatusServiceUnavailable, "not_ready", "the service is starting")
+		return
+	}
+
+	next.ServeHTTP(w, r)
+}
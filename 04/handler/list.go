@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/cohhei/go-to-the-handson/04/db"
+)
+
+// A list without a limit parameter returns the first defaultLimit todos;
+// clients follow X-Next-Cursor or the Link header for the rest.
+const (
+	defaultLimit = 100
+	maxLimit     = 1000
+)
+
+// parseListOptions reads the pagination, sorting and filtering parameters of
+// GET /todo. A cursor is an opaque token for the offset of the next page.
+func parseListOptions(query url.Values) (db.ListOptions, error) {
+	opts := db.ListOptions{
+		Limit: defaultLimit,
+		Sort:  query.Get("sort"),
+		Query: query.Get("q"),
+	}
+
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxLimit {
+			return opts, fmt.Errorf("limit must be between 1 and %d", maxLimit)
+		}
+		opts.Limit = limit
+	}
+
+	cursor, offset := query.Get("cursor"), query.Get("offset")
+	if cursor != "" && offset != "" {
+		return opts, errors.New("cursor and offset cannot be used together")
+	}
+	if cursor != "" {
+		n, err := decodeCursor(cursor)
+		if err != nil {
+			return opts, errors.New("invalid cursor")
+		}
+		opts.Offset = n
+	}
+	if offset != "" {
+		n, err := strconv.Atoi(offset)
+		if err != nil || n < 0 {
+			return opts, errors.New("offset must be a non-negative number")
+		}
+		opts.Offset = n
+	}
+
+	if opts.Sort != "" && !db.IsSortable(opts.Sort) {
+		return opts, fmt.Errorf("cannot sort by %q", opts.Sort)
+	}
+
+	var err error
+	if opts.DueBefore, err = parseTime(query, "due_before"); err != nil {
+		return opts, err
+	}
+	if opts.DueAfter, err = parseTime(query, "due_after"); err != nil {
+		return opts, err
+	}
+
+	return opts, nil
+}
+
+func parseTime(query url.Values, key string) (time.Time, error) {
+	v := query.Get(key)
+	if v == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s must be an RFC 3339 timestamp", key)
+	}
+
+	return t, nil
+}
+
+// setPageHeaders reports the filtered total and, when more rows remain, the
+// cursor of the next page both as X-Next-Cursor and as a Link header.
+func setPageHeaders(w http.ResponseWriter, r *http.Request, opts db.ListOptions, n int, total int) {
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+
+	next := opts.Offset + n
+	if n == 0 || next >= total {
+		return
+	}
+
+	cursor := encodeCursor(next)
+	w.Header().Set("X-Next-Cursor", cursor)
+
+	query := r.URL.Query()
+	query.Del("offset")
+	query.Set("cursor", cursor)
+	w.Header().Set("Link", fmt.Sprintf(`<%s?%s>; rel="next"`, r.URL.Path, query.Encode()))
+}
+
+func encodeCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(offset)))
+}
+
+func decodeCursor(cursor string) (int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := strconv.Atoi(string(b))
+	if err != nil || n < 0 {
+		return 0, errors.New("invalid cursor")
+	}
+
+	return n, nil
+}
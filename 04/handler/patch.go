@@ -0,0 +1,60 @@
+package handler
+
+import (
+	"encoding/json"
+
+	"github.com/cohhei/go-to-the-handson/04/schema"
+)
+
+// mergePatch applies a JSON merge patch (RFC 7396) to todo and returns the
+// patched copy. Members set to null in the patch are reset to their zero value.
+func mergePatch(todo *schema.Todo, patch []byte) (*schema.Todo, error) {
+	var p interface{}
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return nil, err
+	}
+
+	b, err := json.Marshal(todo)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc interface{}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+
+	b, err = json.Marshal(mergeValue(doc, p))
+	if err != nil {
+		return nil, err
+	}
+
+	var patched schema.Todo
+	if err := decodeJSON(b, &patched); err != nil {
+		return nil, err
+	}
+
+	return &patched, nil
+}
+
+func mergeValue(target interface{}, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = map[string]interface{}{}
+	}
+
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+			continue
+		}
+		t[k] = mergeValue(t[k], v)
+	}
+
+	return t
+}
@@ -0,0 +1,142 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/cohhei/go-to-the-handson/04/schema"
+	"github.com/cohhei/go-to-the-handson/04/service"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// problem is an RFC 7807 problem details object. Code is a stable identifier
+// of the error that clients may rely on, unlike Detail.
+type problem struct {
+	Type      string                 `json:"type"`
+	Title     string                 `json:"title"`
+	Status    int                    `json:"status"`
+	Detail    string                 `json:"detail,omitempty"`
+	Code      string                 `json:"code"`
+	RequestID string                 `json:"request_id,omitempty"`
+	Errors    schema.ValidationError `json:"errors,omitempty"`
+
+	// Current is the stored todo that a request conflicted with.
+	Current *schema.Todo `json:"current,omitempty"`
+}
+
+func responseProblem(w http.ResponseWriter, p problem) {
+	p.Type = "about:blank"
+	p.Title = http.StatusText(p.Status)
+	p.RequestID = w.Header().Get(requestIDHeader)
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+
+	json.NewEncoder(w).Encode(p)
+}
+
+func responseError(w http.ResponseWriter, status int, code string, detail string) {
+	responseProblem(w, problem{Status: status, Code: code, Detail: detail})
+}
+
+// responseDecodeError answers a request whose body could not be decoded,
+// with 422 for a schema.ValidationError and 400 otherwise.
+func responseDecodeError(w http.ResponseWriter, err error) {
+	var validationErr schema.ValidationError
+	if errors.As(err, &validationErr) {
+		responseProblem(w, problem{
+			Status: http.StatusUnprocessableEntity,
+			Code:   "validation_failed",
+			Detail: "the todo is invalid",
+			Errors: validationErr,
+		})
+		return
+	}
+
+	responseError(w, http.StatusBadRequest, "invalid_body", err.Error())
+}
+
+// responseServiceError answers with the problem matching an error of the
+// service. The causes of internal errors are logged, never sent.
+func responseServiceError(w http.ResponseWriter, r *http.Request, err error) {
+	if r.Context().Err() == context.DeadlineExceeded {
+		responseError(w, http.StatusServiceUnavailable, "timeout", "the request timed out")
+		return
+	}
+
+	var e *service.Error
+	if !errors.As(err, &e) {
+		e = &service.Error{Kind: service.KindInternal, Code: "internal", Message: "internal error", Err: err}
+	}
+
+	status := statusOf(e.Kind)
+	if status == http.StatusInternalServerError {
+		slog.Error("request failed", "request_id", w.Header().Get(requestIDHeader), "method", r.Method, "path", r.URL.Path, "error", e.Err)
+	}
+
+	if e.Current != nil {
+		setValidators(w, e.Current)
+	}
+
+	responseProblem(w, problem{Status: status, Code: e.Code, Detail: e.Message, Errors: e.Fields, Current: e.Current})
+}
+
+func statusOf(kind service.Kind) int {
+	switch kind {
+	case service.KindNotFound:
+		return http.StatusNotFound
+	case service.KindConflict:
+		return http.StatusConflict
+	case service.KindValidation:
+		return http.StatusUnprocessableEntity
+	case service.KindUnavailable:
+		return http.StatusServiceUnavailable
+	case service.KindPreconditionFailed:
+		return http.StatusPreconditionFailed
+	}
+	return http.StatusInternalServerError
+}
+
+// withRequestID sets the X-Request-ID response header to the ID sent by the
+// client, or to a new one if the client sent none or an unusable one.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(b)
+}
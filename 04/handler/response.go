@@ -0,0 +1,184 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cohhei/go-to-the-handson/04/schema"
+)
+
+const (
+	contentTypeJSON   = "application/json"
+	contentTypeNDJSON = "application/x-ndjson"
+	contentTypeCSV    = "text/csv"
+)
+
+// todoListTypes are the representations of a todo list, the first being the
+// default.
+var todoListTypes = []string{contentTypeJSON, contentTypeNDJSON, contentTypeCSV}
+
+func responseOk(w http.ResponseWriter, body interface{}) {
+	responseJSON(w, http.StatusOK, body)
+}
+
+// responseTodo answers with todo and the validators of its current version.
+func responseTodo(w http.ResponseWriter, todo *schema.Todo) {
+	setValidators(w, todo)
+	responseOk(w, todo)
+}
+
+// responseCreated answers with 201, the created todo and its URL.
+func responseCreated(w http.ResponseWriter, todo *schema.Todo) {
+	w.Header().Set("Location", fmt.Sprintf("/todo/%d", todo.ID))
+	setValidators(w, todo)
+	responseJSON(w, http.StatusCreated, todo)
+}
+
+func responseNoContent(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// responseJSON encodes body before writing anything, so that an encoding
+// failure can still be answered with a 500.
+func responseJSON(w http.ResponseWriter, code int, body interface{}) {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(body); err != nil {
+		slog.Error("encoding the response failed", "request_id", w.Header().Get(requestIDHeader), "error", err)
+		responseError(w, http.StatusInternalServerError, "internal", "internal error")
+		return
+	}
+
+	write(w, code, contentTypeJSON, buf.Bytes())
+}
+
+// responseTodoList writes todoList in the representation chosen by the
+// Accept header of r.
+func responseTodoList(w http.ResponseWriter, r *http.Request, todoList []schema.Todo) {
+	w.Header().Add("Vary", "Accept")
+
+	contentType, ok := negotiate(r.Header.Get("Accept"), todoListTypes)
+	if !ok {
+		responseError(w, http.StatusNotAcceptable, "not_acceptable", "available types are "+strings.Join(todoListTypes, ", "))
+		return
+	}
+
+	var buf bytes.Buffer
+	var err error
+	switch contentType {
+	case contentTypeNDJSON:
+		err = encodeNDJSON(&buf, todoList)
+	case contentTypeCSV:
+		err = encodeCSV(&buf, todoList)
+		contentType += "; charset=utf-8"
+	default:
+		responseOk(w, todoList)
+		return
+	}
+	if err != nil {
+		slog.Error("encoding the response failed", "request_id", w.Header().Get(requestIDHeader), "error", err)
+		responseError(w, http.StatusInternalServerError, "internal", "internal error")
+		return
+	}
+
+	write(w, http.StatusOK, contentType, buf.Bytes())
+}
+
+func write(w http.ResponseWriter, code int, contentType string, b []byte) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+	w.WriteHeader(code)
+
+	if _, err := w.Write(b); err != nil {
+		slog.Debug("writing the response failed", "request_id", w.Header().Get(requestIDHeader), "error", err)
+	}
+}
+
+func encodeNDJSON(buf *bytes.Buffer, todoList []schema.Todo) error {
+	e := json.NewEncoder(buf)
+	for _, t := range todoList {
+		if err := e.Encode(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func encodeCSV(buf *bytes.Buffer, todoList []schema.Todo) error {
+	cw := csv.NewWriter(buf)
+	cw.Write([]string{"id", "title", "note", "due_date", "status", "completed_at", "created_at", "updated_at", "version"})
+	for _, t := range todoList {
+		completedAt := ""
+		if t.CompletedAt != nil {
+			completedAt = t.CompletedAt.Format(time.RFC3339Nano)
+		}
+		cw.Write([]string{strconv.Itoa(t.ID), t.Title, t.Note, t.DueDate.Format(time.RFC3339Nano), string(t.Status), completedAt, t.CreatedAt.Format(time.RFC3339Nano), t.UpdatedAt.Format(time.RFC3339Nano), strconv.Itoa(t.Version)})
+	}
+	cw.Flush()
+
+	return cw.Error()
+}
+
+// negotiate picks the offer that accept, an Accept header, prefers. An empty
+// header accepts the first offer. Ties go to the offer matched by the more
+// specific media range, then to the earlier offer.
+func negotiate(accept string, offers []string) (string, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return offers[0], true
+	}
+
+	best, bestQ, bestSpecificity := "", 0.0, -1
+	for _, offer := range offers {
+		q, specificity := quality(accept, offer)
+		if q > bestQ || (q == bestQ && q > 0 && specificity > bestSpecificity) {
+			best, bestQ, bestSpecificity = offer, q, specificity
+		}
+	}
+
+	return best, bestQ > 0
+}
+
+// quality returns the q value that accept gives to offer, taken from the
+// most specific matching media range, and how specific that range is.
+func quality(accept string, offer string) (float64, int) {
+	offerType, offerSubtype, _ := strings.Cut(offer, "/")
+
+	q, specificity := 0.0, -1
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		typ, subtype, _ := strings.Cut(mediaType, "/")
+
+		s := -1
+		switch {
+		case typ == offerType && subtype == offerSubtype:
+			s = 2
+		case typ == offerType && subtype == "*":
+			s = 1
+		case typ == "*" && subtype == "*":
+			s = 0
+		}
+		if s <= specificity {
+			continue
+		}
+
+		rangeQ := 1.0
+		if v, ok := params["q"]; ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				rangeQ = f
+			}
+		}
+		q, specificity = rangeQ, s
+	}
+
+	return q, specificity
+}
@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+type paramsKey struct{}
+
+// router dispatches requests by path pattern and method. Patterns are matched
+// segment by segment, and a segment written as {name} captures the value of
+// that segment, which handlers read with pathParam.
+type router struct {
+	routes []*route
+}
+
+type route struct {
+	segments []string
+	methods  []string
+	handlers map[string]http.HandlerFunc
+}
+
+func newRouter() *router {
+	return &router{}
+}
+
+func (rt *router) handle(method string, pattern string, h http.HandlerFunc) {
+	segments := splitPath(pattern)
+
+	for _, r := range rt.routes {
+		if equalSegments(r.segments, segments) {
+			r.add(method, h)
+			return
+		}
+	}
+
+	r := &route{
+		segments: segments,
+		handlers: map[string]http.HandlerFunc{},
+	}
+	r.add(method, h)
+	rt.routes = append(rt.routes, r)
+}
+
+// ServeHTTP uses the first registered pattern that matches the path, so
+// static patterns must be registered before overlapping parameterized ones.
+func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segments := splitPath(r.URL.Path)
+
+	for _, route := range rt.routes {
+		params, ok := route.match(segments)
+		if !ok {
+			continue
+		}
+
+		h, ok := route.handlers[r.Method]
+		if !ok {
+			w.Header().Set("Allow", strings.Join(route.methods, ", "))
+			responseError(w, http.StatusMethodNotAllowed, "method_not_allowed", "")
+			return
+		}
+
+		if len(params) > 0 {
+			r = r.WithContext(context.WithValue(r.Context(), paramsKey{}, params))
+		}
+		h(w, r)
+		return
+	}
+
+	responseError(w, http.StatusNotFound, "route_not_found", "")
+}
+
+func (r *route) add(method string, h http.HandlerFunc) {
+	if _, ok := r.handlers[method]; !ok {
+		r.methods = append(r.methods, method)
+	}
+	r.handlers[method] = h
+}
+
+func (r *route) match(segments []string) (map[string]string, bool) {
+	if len(segments) != len(r.segments) {
+		return nil, false
+	}
+
+	var params map[string]string
+	for i, s := range r.segments {
+		if strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}") {
+			if segments[i] == "" {
+				return nil, false
+			}
+			if params == nil {
+				params = map[string]string{}
+			}
+			params[s[1:len(s)-1]] = segments[i]
+			continue
+		}
+
+		if s != segments[i] {
+			return nil, false
+		}
+	}
+
+	return params, true
+}
+
+func pathParam(r *http.Request, name string) string {
+	params, _ := r.Context().Value(paramsKey{}).(map[string]string)
+	return params[name]
+}
+
+func splitPath(path string) []string {
+	return strings.Split(strings.TrimPrefix(path, "/"), "/")
+}
+
+func equalSegments(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -1,31 +1,58 @@
 package handler
 
 import (
+	"context"
 	"net/http"
+	"time"
 
 	"github.com/cohhei/go-to-the-handson/04/db"
+	"github.com/cohhei/go-to-the-handson/04/service"
 )
 
-func SetUpRouting(postgres *db.Postgres) *http.ServeMux {
+type Options struct {
+	// LegacyDeleteBody keeps accepting DELETE /todo with the ID in a JSON
+	// body for clients written before DELETE /todo/{id} existed.
+	LegacyDeleteBody bool
+
+	// QueryTimeout bounds the time spent serving each request, including
+	// the database queries it runs. Zero means no limit.
+	QueryTimeout time.Duration
+}
+
+func SetUpRouting(todos *service.TodoService, opts Options) http.Handler {
 	todoHandler := &todoHandler{
-		postgres: postgres,
-		samples:  &db.Sample{},
+		todos:   todos,
+		samples: service.NewTodoService(&db.Sample{}),
 	}
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/samples", todoHandler.GetSamples)
-	mux.HandleFunc("/todo", func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodGet:
-			todoHandler.getAllTodo(w, r)
-		case http.MethodPost:
-			todoHandler.saveTodo(w, r)
-		case http.MethodDelete:
-			todoHandler.deleteTodo(w, r)
-		default:
-			responseError(w, http.StatusNotFound, "")
-		}
-	})
+	router := newRouter()
+	router.handle(http.MethodGet, "/samples", todoHandler.GetSamples)
+	router.handle(http.MethodGet, "/todo", todoHandler.getAllTodo)
+	router.handle(http.MethodPost, "/todo", todoHandler.saveTodo)
+	if opts.LegacyDeleteBody {
+		router.handle(http.MethodDelete, "/todo", todoHandler.deleteTodoByBody)
+	}
+	router.handle(http.MethodPost, "/todo/batch", todoHandler.saveTodoBatch)
+	router.handle(http.MethodDelete, "/todo/batch", todoHandler.deleteTodoBatch)
+	router.handle(http.MethodGet, "/todo/{id}", todoHandler.getTodo)
+	router.handle(http.MethodPut, "/todo/{id}", todoHandler.updateTodo)
+	router.handle(http.MethodPatch, "/todo/{id}", todoHandler.patchTodo)
+	router.handle(http.MethodDelete, "/todo/{id}", todoHandler.deleteTodo)
+	router.handle(http.MethodPost, "/todo/{id}/complete", todoHandler.completeTodo)
+	router.handle(http.MethodPost, "/todo/{id}/reopen", todoHandler.reopenTodo)
 
-	return mux
+	return withRequestID(withTimeout(router, opts.QueryTimeout))
+}
+
+func withTimeout(next http.Handler, timeout time.Duration) http.Handler {
+	if timeout <= 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
 }
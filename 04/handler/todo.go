@@ -1,9 +1,17 @@
 package handler
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
-	"io/ioutil"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/cohhei/go-to-the-handson/04/db"
 	"github.com/cohhei/go-to-the-handson/04/schema"
@@ -11,16 +19,14 @@ import (
 )
 
 type todoHandler struct {
-	postgres *db.Postgres
-	samples  *db.Sample
+	todos   *service.TodoService
+	samples *service.TodoService
 }
 
 func (handler *todoHandler) GetSamples(w http.ResponseWriter, r *http.Request) {
-	ctx := db.SetRepository(r.Context(), handler.samples)
-
-	todoList, err := service.GetAll(ctx)
+	todoList, err := handler.samples.GetAll(r.Context())
 	if err != nil {
-		responseError(w, http.StatusInternalServerError, err.Error())
+		responseServiceError(w, r, err)
 		return
 	}
 
@@ -28,79 +34,323 @@ func (handler *todoHandler) GetSamples(w http.ResponseWriter, r *http.Request) {
 }
 
 func (handler *todoHandler) saveTodo(w http.ResponseWriter, r *http.Request) {
-	ctx := db.SetRepository(r.Context(), handler.postgres)
+	ctx := r.Context()
 
-	b, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		responseError(w, http.StatusInternalServerError, err.Error())
+	b, ok := readBody(w, r, maxBodySize)
+	if !ok {
 		return
 	}
 
 	var todo schema.Todo
-	if err := json.Unmarshal(b, &todo); err != nil {
-		responseError(w, http.StatusBadRequest, err.Error())
+	if err := decodeJSON(b, &todo); err != nil {
+		responseDecodeError(w, err)
+		return
+	}
+
+	if _, err := handler.todos.Insert(ctx, &todo); err != nil {
+		responseServiceError(w, r, err)
+		return
+	}
+
+	responseCreated(w, &todo)
+}
+
+func (handler *todoHandler) updateTodo(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	pre, ok := requireIfMatch(w, r)
+	if !ok {
 		return
 	}
 
-	id, err := service.Insert(ctx, &todo)
+	b, ok := readBody(w, r, maxBodySize)
+	if !ok {
+		return
+	}
+
+	var todo schema.Todo
+	if err := decodeJSON(b, &todo); err != nil {
+		responseDecodeError(w, err)
+		return
+	}
+	if todo.ID != 0 && todo.ID != id {
+		responseDecodeError(w, errIDChanged)
+		return
+	}
+	todo.ID = id
+
+	if err := handler.todos.Update(ctx, &todo, pre); err != nil {
+		responseServiceError(w, r, err)
+		return
+	}
+
+	responseTodo(w, &todo)
+}
+
+func (handler *todoHandler) patchTodo(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	pre, ok := requireIfMatch(w, r)
+	if !ok {
+		return
+	}
+
+	b, ok := readBody(w, r, maxBodySize)
+	if !ok {
+		return
+	}
+
+	current, err := handler.todos.Get(ctx, id)
 	if err != nil {
-		responseError(w, http.StatusInternalServerError, err.Error())
+		responseServiceError(w, r, err)
+		return
+	}
+
+	todo, err := mergePatch(current, b)
+	if err != nil {
+		responseDecodeError(w, err)
+		return
+	}
+	if todo.ID != id {
+		responseDecodeError(w, errIDChanged)
+		return
+	}
+
+	if err := handler.todos.Update(ctx, todo, pre); err != nil {
+		responseServiceError(w, r, err)
 		return
 	}
 
-	responseOk(w, id)
+	responseTodo(w, todo)
 }
 
-func (handler *todoHandler) deleteTodo(w http.ResponseWriter, r *http.Request) {
-	ctx := db.SetRepository(r.Context(), handler.postgres)
+func (handler *todoHandler) completeTodo(w http.ResponseWriter, r *http.Request) {
+	handler.setStatus(w, r, handler.todos.Complete)
+}
+
+func (handler *todoHandler) reopenTodo(w http.ResponseWriter, r *http.Request) {
+	handler.setStatus(w, r, handler.todos.Reopen)
+}
+
+// setStatus changes the status of a todo with set. Unlike PUT and PATCH, it
+// only checks If-Match when the client sends one, since the new status does
+// not depend on what the client has seen.
+func (handler *todoHandler) setStatus(w http.ResponseWriter, r *http.Request, set func(context.Context, int, service.Precondition) (*schema.Todo, error)) {
+	ctx := r.Context()
 
-	b, err := ioutil.ReadAll(r.Body)
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	todo, err := set(ctx, id, ifMatch(r))
 	if err != nil {
-		responseError(w, http.StatusInternalServerError, err.Error())
+		responseServiceError(w, r, err)
+		return
+	}
+
+	responseTodo(w, todo)
+}
+
+func (handler *todoHandler) deleteTodo(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	pre, ok := requireIfMatch(w, r)
+	if !ok {
+		return
+	}
+
+	if err := handler.todos.Delete(ctx, id, pre); err != nil {
+		responseServiceError(w, r, err)
+		return
+	}
+
+	responseNoContent(w)
+}
+
+func (handler *todoHandler) deleteTodoByBody(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	b, ok := readBody(w, r, maxBodySize)
+	if !ok {
 		return
 	}
 
 	var req struct {
 		ID int `json:"id"`
 	}
-	if err := json.Unmarshal(b, &req); err != nil {
-		responseError(w, http.StatusBadRequest, err.Error())
+	if err := decodeJSON(b, &req); err != nil {
+		responseDecodeError(w, err)
+		return
+	}
+
+	// Clients of the legacy route predate ETags, so If-Match is only
+	// checked when one is sent.
+	if err := handler.todos.Delete(ctx, req.ID, ifMatch(r)); err != nil {
+		responseServiceError(w, r, err)
+		return
+	}
+
+	responseNoContent(w)
+}
+
+func (handler *todoHandler) getTodo(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, ok := pathID(w, r)
+	if !ok {
 		return
 	}
 
-	if err := service.Delete(ctx, req.ID); err != nil {
-		responseError(w, http.StatusInternalServerError, err.Error())
+	todo, err := handler.todos.Get(ctx, id)
+	if err != nil {
+		responseServiceError(w, r, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
+	if notModified(r, todo) {
+		setValidators(w, todo)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	responseTodo(w, todo)
 }
 
 func (handler *todoHandler) getAllTodo(w http.ResponseWriter, r *http.Request) {
-	ctx := db.SetRepository(r.Context(), handler.postgres)
+	ctx := r.Context()
 
-	todoList, err := service.GetAll(ctx)
+	opts, err := parseListOptions(r.URL.Query())
 	if err != nil {
-		responseError(w, http.StatusInternalServerError, err.Error())
+		responseError(w, http.StatusBadRequest, "invalid_query", err.Error())
 		return
 	}
 
-	responseOk(w, todoList)
+	todoList, total, err := handler.todos.List(ctx, opts)
+	if err != nil {
+		responseServiceError(w, r, err)
+		return
+	}
+
+	setPageHeaders(w, r, opts, len(todoList), total)
+	responseTodoList(w, r, todoList)
+}
+
+func pathID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(pathParam(r, "id"))
+	if err != nil {
+		responseError(w, http.StatusNotFound, "not_found", db.ErrNotFound.Error())
+		return 0, false
+	}
+
+	return id, true
+}
+
+// maxBodySize is the largest request body accepted for a single todo. It
+// leaves room for a note of schema.MaxNoteLength escaped characters.
+const maxBodySize = 1 << 20
+
+// readBody reads the body of r, or answers with 413 if it is larger than
+// limit bytes and with 400 if it cannot be read.
+func readBody(w http.ResponseWriter, r *http.Request, limit int64) ([]byte, bool) {
+	b, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		responseError(w, http.StatusRequestEntityTooLarge, "body_too_large", fmt.Sprintf("the body must be at most %d bytes", limit))
+		return nil, false
+	}
+	if err != nil {
+		responseError(w, http.StatusBadRequest, "invalid_body", err.Error())
+		return nil, false
+	}
+
+	return b, true
+}
+
+var errIDChanged = schema.ValidationError{{Field: "id", Code: schema.CodeReadOnly, Message: "id must match the URL"}}
+
+// decodeJSON unmarshals the JSON value in b into v. Fields that v does not
+// have are reported with a schema.ValidationError.
+func decodeJSON(b []byte, v interface{}) error {
+	d := json.NewDecoder(bytes.NewReader(b))
+	if err := d.Decode(v); err != nil {
+		return err
+	}
+
+	if _, err := d.Token(); err != io.EOF {
+		return errors.New("invalid character after top-level value")
+	}
+
+	return unknownFields(b, reflect.TypeOf(v).Elem())
 }
 
-func responseOk(w http.ResponseWriter, body interface{}) {
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", "application/json")
+// unknownFields reports the members of the JSON object in b that t has no
+// field for, if t is a struct type. Like encoding/json, it compares names
+// without regard to case.
+func unknownFields(b []byte, t reflect.Type) error {
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(b, &members); err != nil {
+		return nil
+	}
 
-	json.NewEncoder(w).Encode(body)
+	known := fieldNames(t)
+	var errs schema.ValidationError
+	for name := range members {
+		if !containsFold(known, name) {
+			errs = append(errs, schema.FieldError{Field: name, Code: schema.CodeUnknownField, Message: fmt.Sprintf("unknown field %q", name)})
+		}
+	}
+	if len(errs) > 0 {
+		sort.Slice(errs, func(i, j int) bool { return errs[i].Field < errs[j].Field })
+		return errs
+	}
+
+	return nil
 }
 
-func responseError(w http.ResponseWriter, code int, message string) {
-	w.WriteHeader(code)
-	w.Header().Set("Content-Type", "application/json")
+// fieldNames returns the JSON names of the fields of struct type t.
+func fieldNames(t reflect.Type) []string {
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" || !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		names = append(names, name)
+	}
 
-	body := map[string]string{
-		"error": message,
+	return names
+}
+
+func containsFold(names []string, name string) bool {
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return true
+		}
 	}
-	json.NewEncoder(w).Encode(body)
+
+	return false
 }
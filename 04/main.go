@@ -1,30 +1,248 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
+	"github.com/cohhei/go-to-the-handson/04/config"
 	"github.com/cohhei/go-to-the-handson/04/db"
 	"github.com/cohhei/go-to-the-handson/04/handler"
+	"github.com/cohhei/go-to-the-handson/04/migrations"
+	"github.com/cohhei/go-to-the-handson/04/retry"
+	"github.com/cohhei/go-to-the-handson/04/service"
 )
 
+const usage = `
+usage: todo-api [FLAGS] [migrate COMMAND]
+
+Without a command the API server is started; it applies the pending
+migrations once the database answers. Run 'todo-api -h' for the flags.
+
+Migrate commands:
+  up          Apply all pending migrations
+  down        Revert the latest applied migration
+  status      Show applied and pending migrations
+`
+
 func main() {
-	var postgres *db.Postgres
-	var err error
-	for i := 0; i < 10; i++ {
-		time.Sleep(3 * time.Second)
-		postgres, err = db.ConnectPostgres()
+	cfg, args, err := config.Load(os.Args[0], os.Args[1:], os.Stderr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: cfg.LogLevel})))
+
+	if len(args) > 0 {
+		if args[0] != "migrate" || len(args) < 2 {
+			fmt.Print(usage)
+			os.Exit(2)
+		}
+		migrate(cfg, args[1])
+		return
+	}
+
+	serve(cfg)
+}
+
+// serve runs the API server until SIGINT or SIGTERM, then stops accepting
+// connections, waits up to cfg.ShutdownTimeout for in-flight requests and
+// closes the repository.
+func serve(cfg *config.Config) {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	readiness := handler.NewReadiness()
+	connected := make(chan db.Repository, 1)
+	go func() {
+		defer close(connected)
+
+		repository, err := openRepository(ctx, cfg)
+		if err != nil {
+			if ctx.Err() == nil {
+				fatal(err)
+			}
+			return
+		}
+
+		var check func(ctx context.Context) error
+		if pinger, ok := repository.(interface{ Ping(context.Context) error }); ok {
+			check = pinger.Ping
+		}
+
+		readiness.SetReady(handler.SetUpRouting(service.NewTodoService(repository), handler.Options{
+			LegacyDeleteBody: cfg.LegacyDeleteBody,
+			QueryTimeout:     cfg.QueryTimeout,
+		}), check)
+		slog.Info("ready", "backend", cfg.Backend)
+		connected <- repository
+	}()
+
+	server := &http.Server{
+		Addr:         cfg.Addr,
+		Handler:      readiness,
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+		IdleTimeout:  cfg.IdleTimeout,
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		slog.Info("listening", "addr", cfg.Addr)
+		errc <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errc:
+		fatal(err)
+	case <-ctx.Done():
+	}
+	stop()
+
+	slog.Info("shutting down", "timeout", cfg.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("in-flight requests were not drained", "error", err)
+		server.Close()
+	}
+
+	if repository := <-connected; repository != nil {
+		repository.Close()
 	}
+	slog.Info("stopped")
+}
+
+func migrate(cfg *config.Config, command string) {
+	switch cfg.Backend {
+	case config.BackendPostgres:
+	case config.BackendSQLite:
+		fatal(fmt.Errorf("the %s backend applies its migrations when it opens", cfg.Backend))
+	default:
+		fatal(fmt.Errorf("migrations are not used by the %s backend", cfg.Backend))
+	}
+
+	postgres, err := connectPostgres(context.Background(), cfg)
 	if err != nil {
-		panic(err)
-	} else if postgres == nil {
-		panic("postgres is nil")
+		fatal(err)
 	}
+	defer postgres.Close()
 
-	mux := handler.SetUpRouting(postgres)
+	migrator, err := migrations.NewPostgres(postgres.DB.(*sql.DB))
+	if err != nil {
+		fatal(err)
+	}
+
+	switch command {
+	case "up":
+		applied, err := migrator.Up()
+		for _, m := range applied {
+			fmt.Printf("applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			fatal(err)
+		}
+	case "down":
+		m, err := migrator.Down()
+		if err != nil {
+			fatal(err)
+		}
+		if m == nil {
+			fmt.Println("no migrations to revert")
+			return
+		}
+		fmt.Printf("reverted %04d_%s\n", m.Version, m.Name)
+	case "status":
+		statuses, err := migrator.Status()
+		if err != nil {
+			fatal(err)
+		}
+		for _, s := range statuses {
+			state := "pending"
+			if s.AppliedAt != nil {
+				state = "applied at " + s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d_%s\t%s\n", s.Version, s.Name, state)
+		}
+	default:
+		fmt.Printf("'%s' is not a migrate command.\n", command)
+		os.Exit(2)
+	}
+}
+
+func openRepository(ctx context.Context, cfg *config.Config) (db.Repository, error) {
+	switch cfg.Backend {
+	case config.BackendMemory:
+		return db.NewMemory(), nil
+	case config.BackendSQLite:
+		sqlite, err := db.ConnectSQLite(cfg.SQLitePath)
+		if err != nil {
+			return nil, err
+		}
+		return sqlite, nil
+	case config.BackendFile:
+		store, err := db.OpenFileStore(cfg.FilePath)
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	}
+
+	postgres, err := connectPostgres(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Like SQLite, Postgres is brought up to date before the server is
+	// ready, so that /healthz answers while the database is still starting.
+	if err := migratePostgres(postgres); err != nil {
+		postgres.Close()
+		return nil, err
+	}
+
+	return postgres, nil
+}
+
+func migratePostgres(postgres *db.Postgres) error {
+	migrator, err := migrations.NewPostgres(postgres.DB.(*sql.DB))
+	if err != nil {
+		return err
+	}
+
+	applied, err := migrator.Up()
+	for _, m := range applied {
+		slog.Info("applied migration", "version", m.Version, "name", m.Name)
+	}
+	return err
+}
+
+func connectPostgres(ctx context.Context, cfg *config.Config) (*db.Postgres, error) {
+	backoff := retry.DefaultBackoff
+	backoff.MaxElapsed = cfg.ConnectTimeout
+
+	postgres, err := db.ConnectPostgresWithRetry(ctx, cfg.DSN, backoff)
+	if err != nil {
+		return nil, err
+	}
+
+	// ConnectPostgres always opens a pool.
+	pool := postgres.DB.(*sql.DB)
+	pool.SetMaxOpenConns(cfg.MaxOpenConns)
+	pool.SetMaxIdleConns(cfg.MaxIdleConns)
+	pool.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+
+	return postgres, nil
+}
 
-	fmt.Println("http://localhost:8080")
-	log.Fatal(http.ListenAndServe(":8080", mux))
+func fatal(err error) {
+	slog.Error(err.Error())
+	os.Exit(1)
 }
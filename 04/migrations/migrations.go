@@ -0,0 +1,305 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed postgres/*.sql sqlite/*.sql
+var files embed.FS
+
+const createPostgresTable = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+  VERSION INTEGER PRIMARY KEY,
+  NAME TEXT NOT NULL,
+  APPLIED_AT TIMESTAMP WITH TIME ZONE NOT NULL
+);
+`
+
+const createSQLiteTable = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+  VERSION INTEGER PRIMARY KEY,
+  NAME TEXT NOT NULL,
+  APPLIED_AT TIMESTAMP NOT NULL
+);
+`
+
+// Postgres migrators hold a session advisory lock while they run, so that
+// servers starting together migrate one at a time. The key is derived from
+// the schema, since each schema has its own schema_migrations table.
+const (
+	lockPostgres   = `SELECT pg_advisory_lock(hashtext('schema_migrations.' || current_schema()))`
+	unlockPostgres = `SELECT pg_advisory_unlock(hashtext('schema_migrations.' || current_schema()))`
+)
+
+// Migration is a pair of SQL scripts loaded from files named
+// NNNN_name.up.sql and NNNN_name.down.sql.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// Migrator applies migrations to a database and records each applied
+// version in the schema_migrations table.
+type Migrator struct {
+	db          *sql.DB
+	createTable string
+	lock        string
+	unlock      string
+	migrations  []Migration
+}
+
+func NewPostgres(db *sql.DB) (*Migrator, error) {
+	m, err := newMigrator(db, files, "postgres", createPostgresTable)
+	if err != nil {
+		return nil, err
+	}
+	m.lock, m.unlock = lockPostgres, unlockPostgres
+
+	return m, nil
+}
+
+// NewSQLite returns a Migrator for SQLite, which needs no lock: the database
+// belongs to a single server.
+func NewSQLite(db *sql.DB) (*Migrator, error) {
+	return newMigrator(db, files, "sqlite", createSQLiteTable)
+}
+
+func newMigrator(db *sql.DB, files fs.FS, dir string, createTable string) (*Migrator, error) {
+	migrations, err := load(files, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{db: db, createTable: createTable, migrations: migrations}, nil
+}
+
+// session calls fn with a single connection of m.db, on which m holds its
+// lock if it has one.
+func (m *Migrator) session(fn func(conn *sql.Conn) error) error {
+	ctx := context.Background()
+
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if m.lock != "" {
+		if _, err := conn.ExecContext(ctx, m.lock); err != nil {
+			return err
+		}
+		defer conn.ExecContext(ctx, m.unlock)
+	}
+
+	return fn(conn)
+}
+
+func (m *Migrator) Migrations() []Migration {
+	return m.migrations
+}
+
+// Up applies every pending migration in order, each in its own transaction,
+// and returns the applied ones.
+func (m *Migrator) Up() ([]Migration, error) {
+	var done []Migration
+	err := m.session(func(conn *sql.Conn) error {
+		applied, err := m.applied(conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+
+			err := m.exec(conn, migration.Up, `INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)`,
+				migration.Version, migration.Name, time.Now())
+			if err != nil {
+				return fmt.Errorf("migration %04d_%s: %v", migration.Version, migration.Name, err)
+			}
+			done = append(done, migration)
+		}
+
+		return nil
+	})
+
+	return done, err
+}
+
+// Down reverts the latest applied migration. It returns nil when nothing has
+// been applied.
+func (m *Migrator) Down() (*Migration, error) {
+	var reverted *Migration
+	err := m.session(func(conn *sql.Conn) error {
+		applied, err := m.applied(conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			migration := m.migrations[i]
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+
+			err := m.exec(conn, migration.Down, `DELETE FROM schema_migrations WHERE version = $1`, migration.Version)
+			if err != nil {
+				return fmt.Errorf("migration %04d_%s: %v", migration.Version, migration.Name, err)
+			}
+			reverted = &migration
+			return nil
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return reverted, nil
+}
+
+func (m *Migrator) Status() ([]Status, error) {
+	var applied map[int]time.Time
+	err := m.session(func(conn *sql.Conn) error {
+		var err error
+		applied, err = m.applied(conn)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []Status
+	for _, migration := range m.migrations {
+		status := Status{Migration: migration}
+		if t, ok := applied[migration.Version]; ok {
+			status.AppliedAt = &t
+		}
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
+
+func (m *Migrator) applied(conn *sql.Conn) (map[int]time.Time, error) {
+	ctx := context.Background()
+	if _, err := conn.ExecContext(ctx, m.createTable); err != nil {
+		return nil, err
+	}
+
+	rows, err := conn.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+
+	return applied, rows.Err()
+}
+
+func (m *Migrator) exec(conn *sql.Conn, script string, record string, args ...interface{}) error {
+	tx, err := conn.BeginTx(context.Background(), nil)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(script); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err := tx.Exec(record, args...); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func load(files fs.FS, dir string) ([]Migration, error) {
+	names, err := fs.Glob(files, path.Join(dir, "*.sql"))
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, name := range names {
+		base := path.Base(name)
+		var direction string
+		switch {
+		case strings.HasSuffix(base, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(base, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("%s: want NNNN_name.up.sql or NNNN_name.down.sql", name)
+		}
+
+		parts := strings.SplitN(strings.TrimSuffix(base, "."+direction+".sql"), "_", 2)
+		version, err := strconv.Atoi(parts[0])
+		if err != nil || len(parts) != 2 {
+			return nil, fmt.Errorf("%s: want NNNN_name.up.sql or NNNN_name.down.sql", name)
+		}
+
+		b, err := fs.ReadFile(files, name)
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: parts[1]}
+			byVersion[version] = migration
+		}
+		if migration.Name != parts[1] {
+			return nil, fmt.Errorf("%s: version %d is already used by %s", name, version, migration.Name)
+		}
+
+		if direction == "up" {
+			migration.Up = string(b)
+		} else {
+			migration.Down = string(b)
+		}
+	}
+
+	var migrations []Migration
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s needs both up and down scripts", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
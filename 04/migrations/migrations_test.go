@@ -0,0 +1,47 @@
+package migrations
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoad(t *testing.T) {
+	postgres, err := load(files, "postgres")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sqlite, err := load(files, "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(postgres) != len(sqlite) {
+		t.Fatalf("Both dialects need the same migrations, Got: %d and %d", len(postgres), len(sqlite))
+	}
+
+	for i := range postgres {
+		if postgres[i].Version != i+1 || sqlite[i].Version != i+1 || postgres[i].Name != sqlite[i].Name {
+			t.Fatalf("Want: version %d, Got: %d_%s and %d_%s", i+1,
+				postgres[i].Version, postgres[i].Name, sqlite[i].Version, sqlite[i].Name)
+		}
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []fstest.MapFS{
+		{"sql/0001_create.up.sql": {Data: []byte("CREATE TABLE a ();")}},
+		{"sql/create.up.sql": {Data: []byte("CREATE TABLE a ();")}},
+		{"sql/0001_create.sql": {Data: []byte("CREATE TABLE a ();")}},
+		{
+			"sql/0001_create.up.sql":  {Data: []byte("CREATE TABLE a ();")},
+			"sql/0001_other.down.sql": {Data: []byte("DROP TABLE a;")},
+		},
+	}
+
+	for _, files := range tests {
+		if _, err := load(files, "sql"); err == nil {
+			t.Fatalf("Want an error for %v", files)
+		}
+	}
+}
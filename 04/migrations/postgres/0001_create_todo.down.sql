@@ -0,0 +1,2 @@
+DROP TABLE todo;
+DROP SEQUENCE todo_id;
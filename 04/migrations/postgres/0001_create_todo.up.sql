@@ -0,0 +1,9 @@
+-- Databases created by the former postgres/up.sql already have this schema,
+-- which the first migration adopts as it is.
+CREATE SEQUENCE IF NOT EXISTS todo_id START 1;
+CREATE TABLE IF NOT EXISTS todo (
+  ID serial PRIMARY KEY,
+  TITLE TEXT NOT NULL,
+  NOTE TEXT,
+  DUE_DATE TIMESTAMP WITH TIME ZONE
+);
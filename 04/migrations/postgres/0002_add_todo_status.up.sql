@@ -0,0 +1,3 @@
+ALTER TABLE todo
+  ADD COLUMN STATUS TEXT NOT NULL DEFAULT 'open',
+  ADD COLUMN COMPLETED_AT TIMESTAMP WITH TIME ZONE;
@@ -0,0 +1,2 @@
+ALTER TABLE todo
+  ADD COLUMN UPDATED_AT TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now();
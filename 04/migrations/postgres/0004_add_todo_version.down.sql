@@ -0,0 +1,2 @@
+ALTER TABLE todo
+  DROP COLUMN VERSION;
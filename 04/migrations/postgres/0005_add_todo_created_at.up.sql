@@ -0,0 +1,6 @@
+ALTER TABLE todo
+  ADD COLUMN CREATED_AT TIMESTAMP WITH TIME ZONE;
+UPDATE todo SET CREATED_AT = UPDATED_AT;
+ALTER TABLE todo
+  ALTER COLUMN CREATED_AT SET NOT NULL,
+  ALTER COLUMN CREATED_AT SET DEFAULT now();
@@ -0,0 +1,91 @@
+package migrations_test
+
+import (
+	"database/sql"
+	"sync"
+	"testing"
+
+	"github.com/cohhei/go-to-the-handson/04/migrations"
+	"github.com/cohhei/go-to-the-handson/04/testdb"
+)
+
+// legacySchema is what postgres/up.sql created before there were
+// migrations.
+const legacySchema = `
+CREATE SEQUENCE todo_id START 1;
+CREATE TABLE todo (
+  ID serial PRIMARY KEY,
+  TITLE TEXT NOT NULL,
+  NOTE TEXT,
+  DUE_DATE TIMESTAMP WITH TIME ZONE
+);
+`
+
+func TestPostgresAdoptLegacySchema(t *testing.T) {
+	db := testdb.Setup(t)
+	migrator := revertAll(t, db)
+
+	if _, err := db.Exec(legacySchema); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO todo (title) VALUES ('title1')`); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := migrator.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	var title, status string
+	if err := db.QueryRow(`SELECT title, status FROM todo`).Scan(&title, &status); err != nil {
+		t.Fatal(err)
+	}
+	if title != "title1" || status != "open" {
+		t.Fatalf("Want: title1 open, Got: %v %v", title, status)
+	}
+}
+
+func TestPostgresConcurrentUp(t *testing.T) {
+	migrator := revertAll(t, testdb.Setup(t))
+
+	// Servers starting together must not apply a migration twice.
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	applied := 0
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			done, err := migrator.Up()
+			if err != nil {
+				t.Error(err)
+			}
+			mu.Lock()
+			applied += len(done)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if want := len(migrator.Migrations()); applied != want {
+		t.Fatalf("Want: %v, Got: %v", want, applied)
+	}
+}
+
+// revertAll reverts every migration applied to db and returns the migrator.
+func revertAll(t *testing.T, db *sql.DB) *migrations.Migrator {
+	migrator, err := migrations.NewPostgres(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for {
+		m, err := migrator.Down()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m == nil {
+			return migrator
+		}
+	}
+}
@@ -0,0 +1,6 @@
+CREATE TABLE todo (
+  ID INTEGER PRIMARY KEY AUTOINCREMENT,
+  TITLE TEXT NOT NULL,
+  NOTE TEXT,
+  DUE_DATE TIMESTAMP
+);
@@ -0,0 +1,2 @@
+ALTER TABLE todo DROP COLUMN COMPLETED_AT;
+ALTER TABLE todo DROP COLUMN STATUS;
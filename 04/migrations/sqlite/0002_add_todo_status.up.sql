@@ -0,0 +1,2 @@
+ALTER TABLE todo ADD COLUMN STATUS TEXT NOT NULL DEFAULT 'open';
+ALTER TABLE todo ADD COLUMN COMPLETED_AT TIMESTAMP;
@@ -0,0 +1 @@
+ALTER TABLE todo ADD COLUMN VERSION INTEGER NOT NULL DEFAULT 1;
@@ -0,0 +1,2 @@
+ALTER TABLE todo ADD COLUMN CREATED_AT TIMESTAMP NOT NULL DEFAULT '1970-01-01 00:00:00';
+UPDATE todo SET CREATED_AT = UPDATED_AT;
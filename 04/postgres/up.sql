@@ -1,8 +0,0 @@
-DROP TABLE IF EXISTS todo;
-CREATE SEQUENCE todo_id START 1;
-CREATE TABLE todo (
-  ID serial PRIMARY KEY,
-  TITLE TEXT NOT NULL,
-  NOTE TEXT,
-  DUE_DATE TIMESTAMP WITH TIME ZONE
-);
\ No newline at end of file
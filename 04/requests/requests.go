@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
 )
@@ -16,8 +17,12 @@ usage: todo COMMAND
 Commands:
   samples     Get sample todo tasks
   all         Get all todo tasks
+  get         Get a todo task
   add         Add new todo task
+  import      Add the todo tasks in a JSON file
   delete      Remove a todo task
+  complete    Mark a todo task as done
+  reopen      Reopen a todo task
 `
 
 func main() {
@@ -30,11 +35,25 @@ func main() {
 	case "samples":
 		get("samples")
 	case "all":
-		get("todo")
+		getAll()
+	case "get":
+		if len(os.Args) < 3 {
+			fmt.Print("usage: todo get TASK_ID")
+			return
+		}
+		get("todo/" + os.Args[2])
 	case "add":
 		add()
+	case "import":
+		importTodos()
 	case "delete":
 		del()
+	case "complete", "reopen":
+		if len(os.Args) < 3 {
+			fmt.Printf("usage: todo %s TASK_ID", command)
+			return
+		}
+		post(fmt.Sprintf("todo/%s/%s", os.Args[2], command))
 	default:
 		fmt.Printf("'%s' is not a todo command.", command)
 	}
@@ -59,6 +78,66 @@ func get(path string) {
 	fmt.Println(string(b))
 }
 
+// getAll prints every todo. GET /todo returns one page at a time, so the
+// pages are followed through X-Next-Cursor and printed as one array.
+func getAll() {
+	todos := []json.RawMessage{}
+	cursor := ""
+	for {
+		path := "http://localhost:8080/todo?limit=1000"
+		if cursor != "" {
+			path += "&cursor=" + url.QueryEscape(cursor)
+		}
+
+		res, err := http.Get(path)
+		if err != nil {
+			panic(err)
+		}
+
+		b, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			panic(err)
+		}
+		if res.StatusCode != http.StatusOK {
+			fmt.Println(string(b))
+			return
+		}
+
+		var page []json.RawMessage
+		if err := json.Unmarshal(b, &page); err != nil {
+			panic(err)
+		}
+		todos = append(todos, page...)
+
+		cursor = res.Header.Get("X-Next-Cursor")
+		if cursor == "" {
+			break
+		}
+	}
+
+	b, err := json.Marshal(todos)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(string(b))
+}
+
+func post(path string) {
+	res, err := http.Post(fmt.Sprintf("http://localhost:8080/%s", path), "application/json", nil)
+	if err != nil {
+		panic(err)
+	}
+	defer res.Body.Close()
+
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println(string(b))
+}
+
 const usage_add = `
 usage: todo add TODO_NAME TODO_NOTE DUE_DATE
 `
@@ -108,27 +187,56 @@ func add() {
 	fmt.Println(string(b))
 }
 
-func del() {
+const usage_import = `
+usage: todo import FILE
+
+FILE holds a JSON array of todo tasks, which are added all at once.
+`
+
+func importTodos() {
 	if len(os.Args) < 3 {
-		fmt.Print("usage: todo delete TASK_ID")
+		fmt.Print(usage_import)
 		return
 	}
 
-	id, err := strconv.Atoi(os.Args[2])
+	b, err := ioutil.ReadFile(os.Args[2])
 	if err != nil {
-		fmt.Println("TASK_ID should be number")
+		fmt.Println(err)
 		return
 	}
 
-	b, err := json.Marshal(map[string]int{"id": id})
+	res, err := http.Post("http://localhost:8080/todo/batch", "application/json", bytes.NewReader(b))
+	if err != nil {
+		panic(err)
+	}
+	defer res.Body.Close()
+
+	b, err = ioutil.ReadAll(res.Body)
 	if err != nil {
 		panic(err)
 	}
 
-	req, err := http.NewRequest(http.MethodDelete, "http://localhost:8080/todo", bytes.NewReader(b))
+	fmt.Println(string(b))
+}
+
+func del() {
+	if len(os.Args) < 3 {
+		fmt.Print("usage: todo delete TASK_ID")
+		return
+	}
+
+	id, err := strconv.Atoi(os.Args[2])
+	if err != nil {
+		fmt.Println("TASK_ID should be number")
+		return
+	}
+
+	req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("http://localhost:8080/todo/%d", id), nil)
 	if err != nil {
 		panic(err)
 	}
+	// Delete the task whatever its current version is.
+	req.Header.Set("If-Match", "*")
 
 	client := &http.Client{}
 	res, err := client.Do(req)
@@ -0,0 +1,108 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"time"
+)
+
+// Backoff describes how long Do waits between attempts. The delay starts at
+// Initial, is multiplied by Multiplier after every failure up to Max, and is
+// randomized by ±Jitter (a fraction of the delay). Do gives up once the next
+// attempt would start after MaxElapsed; zero means retrying until the context
+// is done.
+type Backoff struct {
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64
+	Jitter     float64
+	MaxElapsed time.Duration
+
+	// Logger receives a record for every failed attempt. It defaults to
+	// slog.Default().
+	Logger *slog.Logger
+}
+
+var DefaultBackoff = Backoff{
+	Initial:    500 * time.Millisecond,
+	Max:        10 * time.Second,
+	Multiplier: 2,
+	Jitter:     0.5,
+	MaxElapsed: time.Minute,
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps err so that Do returns err at once instead of retrying.
+func Permanent(err error) error {
+	return &permanentError{err}
+}
+
+// Do calls op until it succeeds, fails with a Permanent error, the backoff
+// gives up or ctx is done, and returns the last error in the latter cases.
+func Do(ctx context.Context, b Backoff, name string, op func(ctx context.Context) error) error {
+	logger := b.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	start := time.Now()
+	delay := b.Initial
+	for attempt := 1; ; attempt++ {
+		err := op(ctx)
+		if err == nil {
+			if attempt > 1 {
+				logger.Info(name+" succeeded", "attempt", attempt)
+			}
+			return nil
+		}
+
+		var permanent *permanentError
+		if errors.As(err, &permanent) {
+			return permanent.err
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		wait := b.jitter(delay)
+		if b.MaxElapsed > 0 && time.Since(start)+wait > b.MaxElapsed {
+			logger.Error(name+" failed, giving up", "attempt", attempt, "error", err)
+			return fmt.Errorf("%s: giving up after %d attempts: %w", name, attempt, err)
+		}
+		logger.Warn(name+" failed", "attempt", attempt, "error", err, "retry_in", wait)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		if b.Multiplier > 1 {
+			delay = time.Duration(float64(delay) * b.Multiplier)
+		}
+		if b.Max > 0 && delay > b.Max {
+			delay = b.Max
+		}
+	}
+}
+
+func (b Backoff) jitter(d time.Duration) time.Duration {
+	if b.Jitter <= 0 || d <= 0 {
+		return d
+	}
+
+	delta := b.Jitter * float64(d)
+	return time.Duration(float64(d) - delta + rand.Float64()*2*delta)
+}
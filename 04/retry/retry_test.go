@@ -0,0 +1,81 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+var testBackoff = Backoff{
+	Initial:    time.Millisecond,
+	Max:        4 * time.Millisecond,
+	Multiplier: 2,
+	Jitter:     0.5,
+	Logger:     slog.New(slog.NewTextHandler(io.Discard, nil)),
+}
+
+func TestDo(t *testing.T) {
+	attempts := 0
+	err := Do(context.Background(), testBackoff, "op", func(ctx context.Context) error {
+		attempts++
+		if attempts < 3 {
+			return errors.New("not yet")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if attempts != 3 {
+		t.Fatalf("Want: 3, Got: %v", attempts)
+	}
+}
+
+func TestDoMaxElapsed(t *testing.T) {
+	b := testBackoff
+	b.MaxElapsed = 20 * time.Millisecond
+
+	want := errors.New("down")
+	err := Do(context.Background(), b, "op", func(ctx context.Context) error {
+		return want
+	})
+
+	if !errors.Is(err, want) {
+		t.Fatalf("Want: %v, Got: %v", want, err)
+	}
+}
+
+func TestDoPermanent(t *testing.T) {
+	want := errors.New("invalid")
+
+	attempts := 0
+	err := Do(context.Background(), testBackoff, "op", func(ctx context.Context) error {
+		attempts++
+		return Permanent(want)
+	})
+
+	if err != want || attempts != 1 {
+		t.Fatalf("Want: %v after 1 attempt, Got: %v after %d", want, err, attempts)
+	}
+}
+
+func TestDoCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	attempts := 0
+	err := Do(ctx, testBackoff, "op", func(ctx context.Context) error {
+		attempts++
+		if attempts == 2 {
+			cancel()
+		}
+		return errors.New("down")
+	})
+
+	if err != context.Canceled || attempts != 2 {
+		t.Fatalf("Want: %v after 2 attempts, Got: %v after %d", context.Canceled, err, attempts)
+	}
+}
@@ -2,9 +2,37 @@ package schema
 
 import "time"
 
+type Status string
+
+const (
+	StatusOpen       Status = "open"
+	StatusInProgress Status = "in_progress"
+	StatusDone       Status = "done"
+	StatusArchived   Status = "archived"
+)
+
+func (s Status) Valid() bool {
+	switch s {
+	case StatusOpen, StatusInProgress, StatusDone, StatusArchived:
+		return true
+	}
+	return false
+}
+
 type Todo struct {
-	ID      int       `json:"id"`
-	Title   string    `json:"title"`
-	Note    string    `json:"note"`
-	DueDate time.Time `json:"due_date"`
+	ID          int        `json:"id"`
+	Title       string     `json:"title"`
+	Note        string     `json:"note"`
+	DueDate     time.Time  `json:"due_date"`
+	Status      Status     `json:"status"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+
+	// CreatedAt is set by the repository when the todo is inserted, and
+	// UpdatedAt whenever it is written.
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// Version starts at 1 and is incremented by the repository whenever the
+	// todo is written.
+	Version int `json:"version"`
 }
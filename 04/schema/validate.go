@@ -0,0 +1,80 @@
+package schema
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	MaxTitleLength = 200
+	MaxNoteLength  = 10000
+)
+
+// Due dates other than the zero time, which means no due date, must fall
+// within this range.
+var (
+	MinDueDate = time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)
+	MaxDueDate = time.Date(9999, 12, 31, 23, 59, 59, 0, time.UTC)
+)
+
+// Codes of FieldError.
+const (
+	CodeRequired     = "required"
+	CodeTooLong      = "too_long"
+	CodeOutOfRange   = "out_of_range"
+	CodeInvalid      = "invalid"
+	CodeReadOnly     = "read_only"
+	CodeUnknownField = "unknown_field"
+)
+
+// FieldError describes why the value of a JSON field is rejected.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every rejected field of a todo.
+type ValidationError []FieldError
+
+func (e ValidationError) Error() string {
+	messages := make([]string, len(e))
+	for i, f := range e {
+		messages[i] = f.Message
+	}
+	return "invalid todo: " + strings.Join(messages, "; ")
+}
+
+// Validate checks the fields a client may set, once defaults have been
+// applied, and returns a ValidationError if any of them is invalid.
+func (t *Todo) Validate() error {
+	var errs ValidationError
+
+	title := strings.TrimSpace(t.Title)
+	switch {
+	case title == "":
+		errs = append(errs, FieldError{"title", CodeRequired, "title is required"})
+	case utf8.RuneCountInString(t.Title) > MaxTitleLength:
+		errs = append(errs, FieldError{"title", CodeTooLong, fmt.Sprintf("title must be at most %d characters", MaxTitleLength)})
+	}
+
+	if utf8.RuneCountInString(t.Note) > MaxNoteLength {
+		errs = append(errs, FieldError{"note", CodeTooLong, fmt.Sprintf("note must be at most %d characters", MaxNoteLength)})
+	}
+
+	if !t.DueDate.IsZero() && (t.DueDate.Before(MinDueDate) || t.DueDate.After(MaxDueDate)) {
+		errs = append(errs, FieldError{"due_date", CodeOutOfRange, fmt.Sprintf("due_date must be between %d and %d", MinDueDate.Year(), MaxDueDate.Year())})
+	}
+
+	if !t.Status.Valid() {
+		errs = append(errs, FieldError{"status", CodeInvalid, fmt.Sprintf("status must be one of %s, %s, %s or %s", StatusOpen, StatusInProgress, StatusDone, StatusArchived)})
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
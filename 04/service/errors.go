@@ -0,0 +1,82 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"github.com/cohhei/go-to-the-handson/04/db"
+	"github.com/cohhei/go-to-the-handson/04/schema"
+)
+
+type Kind int
+
+const (
+	KindInternal Kind = iota
+	KindNotFound
+	KindConflict
+	KindValidation
+	KindUnavailable
+	KindPreconditionFailed
+)
+
+// Error is the error returned by TodoService. Code is stable and Message is
+// safe to show to clients; Err, the cause, may hold driver messages and is
+// only meant for logs. Current is the stored todo when a write conflicts
+// with another one or its precondition fails.
+type Error struct {
+	Kind    Kind
+	Code    string
+	Message string
+	Fields  schema.ValidationError
+	Current *schema.Todo
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil && e.Err.Error() != e.Message {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// translate turns the errors of the repository and of the checks of the
+// service into an *Error.
+func translate(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var e *Error
+	var batchErr BatchError
+	if errors.As(err, &e) || errors.As(err, &batchErr) {
+		return err
+	}
+
+	var validationErr schema.ValidationError
+	var conflictErr *db.ConflictError
+	var preconditionErr *PreconditionFailedError
+	switch {
+	case errors.As(err, &validationErr):
+		return &Error{Kind: KindValidation, Code: "validation_failed", Message: "the todo is invalid", Fields: validationErr, Err: err}
+	case errors.Is(err, db.ErrNotFound):
+		return &Error{Kind: KindNotFound, Code: "not_found", Message: "todo not found", Err: err}
+	case errors.As(err, &conflictErr):
+		return &Error{Kind: KindConflict, Code: "version_conflict", Message: "the todo has been modified by someone else", Current: conflictErr.Current, Err: err}
+	case errors.As(err, &preconditionErr):
+		return &Error{Kind: KindPreconditionFailed, Code: "precondition_failed", Message: "the todo has been modified", Current: preconditionErr.Current, Err: err}
+	case errors.Is(err, ErrInvalidStatus):
+		return &Error{Kind: KindValidation, Code: "invalid_status", Message: err.Error(), Err: err}
+	case errors.Is(err, ErrInvalidTransition):
+		return &Error{Kind: KindConflict, Code: "invalid_transition", Message: err.Error(), Err: err}
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return &Error{Kind: KindUnavailable, Code: "timeout", Message: "the request timed out", Err: err}
+	case db.IsUnavailable(err):
+		return &Error{Kind: KindUnavailable, Code: "unavailable", Message: "the database is unavailable", Err: err}
+	}
+
+	return &Error{Kind: KindInternal, Code: "internal", Message: "internal error", Err: err}
+}
@@ -2,23 +2,299 @@ package service
 
 import (
 	"context"
+	"errors"
+	"fmt"
 
 	"github.com/cohhei/go-to-the-handson/04/db"
 	"github.com/cohhei/go-to-the-handson/04/schema"
 )
 
-func Close(ctx context.Context) {
-	db.Close(ctx)
+var (
+	ErrInvalidStatus     = errors.New("invalid status")
+	ErrInvalidTransition = errors.New("invalid status transition")
+)
+
+// Precondition reports whether a todo may be changed given current, the todo
+// as stored. It is checked in the transaction making the change, so that no
+// other change can slip in between.
+type Precondition func(current *schema.Todo) bool
+
+// PreconditionFailedError is returned when a Precondition does not hold.
+// Current is the todo as stored.
+type PreconditionFailedError struct {
+	Current *schema.Todo
+}
+
+func (e *PreconditionFailedError) Error() string {
+	return fmt.Sprintf("todo %d does not match the precondition, its current version is %d", e.Current.ID, e.Current.Version)
+}
+
+func check(pre Precondition, current *schema.Todo) error {
+	if pre != nil && !pre(current) {
+		return &PreconditionFailedError{Current: current}
+	}
+	return nil
+}
+
+// transitions lists the statuses a todo may move to from each status.
+var transitions = map[schema.Status][]schema.Status{
+	schema.StatusOpen:       {schema.StatusInProgress, schema.StatusDone, schema.StatusArchived},
+	schema.StatusInProgress: {schema.StatusOpen, schema.StatusDone, schema.StatusArchived},
+	schema.StatusDone:       {schema.StatusOpen, schema.StatusArchived},
+	schema.StatusArchived:   {schema.StatusOpen},
+}
+
+type TodoService struct {
+	repository db.Repository
+}
+
+func NewTodoService(repository db.Repository) *TodoService {
+	return &TodoService{repository: repository}
+}
+
+func (s *TodoService) Close() {
+	s.repository.Close()
+}
+
+// BatchError reports the invalid todos of a batch by their index.
+type BatchError map[int]error
+
+func (e BatchError) Error() string {
+	return fmt.Sprintf("%d of the todos are invalid", len(e))
+}
+
+func (s *TodoService) Insert(ctx context.Context, todo *schema.Todo) (int, error) {
+	if err := prepareInsert(todo); err != nil {
+		return -1, translate(err)
+	}
+
+	id, err := s.repository.Insert(ctx, todo)
+	if err != nil {
+		return -1, translate(err)
+	}
+	todo.ID = id
+
+	return id, nil
+}
+
+// InsertMany stores all of todos or, if any of them is invalid, none of
+// them and returns a BatchError of *Error.
+func (s *TodoService) InsertMany(ctx context.Context, todos []schema.Todo) ([]int, error) {
+	errs := BatchError{}
+	for i := range todos {
+		if err := prepareInsert(&todos[i]); err != nil {
+			errs[i] = translate(err)
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	ids, err := s.repository.InsertMany(ctx, todos)
+	if err != nil {
+		return nil, translate(err)
+	}
+
+	return ids, nil
+}
+
+// Update replaces the todo with the same ID. An empty status keeps the
+// current one; any other status must be reachable from the current one. A
+// nonzero todo.Version must be the current one, and a nil pre always holds.
+func (s *TodoService) Update(ctx context.Context, todo *schema.Todo, pre Precondition) error {
+	return translate(s.repository.WithinTx(ctx, func(repository db.Repository) error {
+		current, err := repository.Get(ctx, todo.ID)
+		if err != nil {
+			return err
+		}
+		if err := check(pre, current); err != nil {
+			return err
+		}
+
+		// Work on a copy, so that a retried transaction starts over from
+		// what the caller passed.
+		next := *todo
+		if next.Status == "" {
+			next.Status = current.Status
+		}
+		if next.Version == 0 {
+			next.Version = current.Version
+		}
+		if err := next.Validate(); err != nil {
+			return err
+		}
+		if err := transition(current, &next); err != nil {
+			return err
+		}
+
+		if err := repository.Update(ctx, &next); err != nil {
+			return err
+		}
+
+		*todo = next
+		return nil
+	}))
 }
 
-func Insert(ctx context.Context, todo *schema.Todo) (int, error) {
-	return db.Insert(ctx, todo)
+func (s *TodoService) Complete(ctx context.Context, id int, pre Precondition) (*schema.Todo, error) {
+	return s.setStatus(ctx, id, schema.StatusDone, pre)
 }
 
-func Delete(ctx context.Context, id int) error {
-	return db.Delete(ctx, id)
+func (s *TodoService) Reopen(ctx context.Context, id int, pre Precondition) (*schema.Todo, error) {
+	return s.setStatus(ctx, id, schema.StatusOpen, pre)
 }
 
-func GetAll(ctx context.Context) ([]schema.Todo, error) {
-	return db.GetAll(ctx)
+func (s *TodoService) Delete(ctx context.Context, id int, pre Precondition) error {
+	if pre == nil {
+		return translate(s.repository.Delete(ctx, id))
+	}
+
+	return translate(s.repository.WithinTx(ctx, func(repository db.Repository) error {
+		current, err := repository.Get(ctx, id)
+		if err != nil {
+			return err
+		}
+		if err := check(pre, current); err != nil {
+			return err
+		}
+
+		return repository.Delete(ctx, id)
+	}))
+}
+
+func (s *TodoService) DeleteMany(ctx context.Context, ids []int) ([]int, error) {
+	deleted, err := s.repository.DeleteMany(ctx, ids)
+	if err != nil {
+		return nil, translate(err)
+	}
+
+	return deleted, nil
+}
+
+func (s *TodoService) Get(ctx context.Context, id int) (*schema.Todo, error) {
+	todo, err := s.repository.Get(ctx, id)
+	if err != nil {
+		return nil, translate(err)
+	}
+
+	return todo, nil
+}
+
+func (s *TodoService) GetAll(ctx context.Context) ([]schema.Todo, error) {
+	todoList, err := s.repository.GetAll(ctx)
+	if err != nil {
+		return nil, translate(err)
+	}
+
+	return todoList, nil
+}
+
+func (s *TodoService) List(ctx context.Context, opts db.ListOptions) ([]schema.Todo, int, error) {
+	todoList, total, err := s.repository.List(ctx, opts)
+	if err != nil {
+		return nil, 0, translate(err)
+	}
+
+	return todoList, total, nil
+}
+
+func (s *TodoService) setStatus(ctx context.Context, id int, status schema.Status, pre Precondition) (*schema.Todo, error) {
+	var todo schema.Todo
+	err := s.repository.WithinTx(ctx, func(repository db.Repository) error {
+		current, err := repository.Get(ctx, id)
+		if err != nil {
+			return err
+		}
+		if err := check(pre, current); err != nil {
+			return err
+		}
+
+		// A todo already in status is left alone, so that its version and
+		// the ETags clients hold stay valid.
+		todo = *current
+		if current.Status == status {
+			return nil
+		}
+
+		todo.Status = status
+		if err := transition(current, &todo); err != nil {
+			return err
+		}
+
+		return repository.Update(ctx, &todo)
+	})
+	if err != nil {
+		return nil, translate(err)
+	}
+
+	return &todo, nil
+}
+
+// prepareInsert validates a todo about to be inserted and sets the fields the
+// service maintains. Fields the server assigns must not be set.
+func prepareInsert(todo *schema.Todo) error {
+	if todo.Status == "" {
+		todo.Status = schema.StatusOpen
+	}
+
+	var errs schema.ValidationError
+	readOnly := func(field string, set bool) {
+		if set {
+			errs = append(errs, schema.FieldError{Field: field, Code: schema.CodeReadOnly, Message: field + " is assigned by the server"})
+		}
+	}
+	readOnly("id", todo.ID != 0)
+	readOnly("version", todo.Version != 0)
+	readOnly("created_at", !todo.CreatedAt.IsZero())
+	readOnly("updated_at", !todo.UpdatedAt.IsZero())
+	readOnly("completed_at", todo.CompletedAt != nil)
+	if err := todo.Validate(); err != nil {
+		errs = append(errs, err.(schema.ValidationError)...)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+
+	if todo.Status == schema.StatusDone {
+		now := db.Now()
+		todo.CompletedAt = &now
+	}
+
+	return nil
+}
+
+// transition validates the move from current to next.Status and sets
+// next.CompletedAt, which is only ever maintained here.
+func transition(current *schema.Todo, next *schema.Todo) error {
+	if !next.Status.Valid() {
+		return ErrInvalidStatus
+	}
+
+	if next.Status == current.Status {
+		next.CompletedAt = current.CompletedAt
+		return nil
+	}
+
+	allowed := false
+	for _, s := range transitions[current.Status] {
+		if s == next.Status {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return ErrInvalidTransition
+	}
+
+	switch next.Status {
+	case schema.StatusDone:
+		now := db.Now()
+		next.CompletedAt = &now
+	case schema.StatusArchived:
+		next.CompletedAt = current.CompletedAt
+	default:
+		next.CompletedAt = nil
+	}
+
+	return nil
 }